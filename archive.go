@@ -0,0 +1,72 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// ArchiveData is the data fetched from one whisper archive.
+type ArchiveData struct {
+	Retention carbonzipperpb3.Retention
+	Data      *carbonzipperpb3.FetchResponse
+}
+
+// FetchArchives fetches the data of the metric from each whisper archive
+// separately, finest precision first. Whisper serves a fetch from the
+// finest archive whose retention covers the start of the requested range,
+// and returns the points after from up to and including until, so the range
+// of archive i is the part of the timeline which only that archive covers,
+// relative to now: (now-retention[i], now-retention[i-1]].
+// The start of each range is moved forward by one point so that the clock
+// difference between the client and the server does not make the server
+// choose a coarser archive, and then rounded up to a point of the next
+// coarser archive so that the range of that archive ends exactly where
+// this one starts.
+func (c *Client) FetchArchives(name string, now time.Time) ([]ArchiveData, error) {
+	return c.FetchArchivesContext(context.Background(), name, now)
+}
+
+func (c *Client) FetchArchivesContext(ctx context.Context, name string, now time.Time) ([]ArchiveData, error) {
+	info, err := c.GetMetricInfoContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Retentions) == 0 {
+		return nil, errors.New("no retentions in info for " + name)
+	}
+
+	archives := make([]ArchiveData, 0, len(info.Retentions))
+	until := now.Unix()
+	for i, r := range info.Retentions {
+		step := int64(r.SecondsPerPoint)
+		retention := int64(r.NumberOfPoints) * step
+		boundary := step
+		if i+1 < len(info.Retentions) {
+			boundary = int64(info.Retentions[i+1].SecondsPerPoint)
+		}
+		start := roundUp(now.Unix()-retention+2*step, boundary)
+		if start > until {
+			continue
+		}
+		data, err := c.FetchDataContext(ctx, name, time.Unix(start-step, 0), time.Unix(until, 0))
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, ArchiveData{
+			Retention: r,
+			Data:      data,
+		})
+		until = start - 1
+	}
+	return archives, nil
+}
+
+func roundUp(t, step int64) int64 {
+	if r := t % step; r != 0 {
+		return t - r + step
+	}
+	return t
+}
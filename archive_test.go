@@ -0,0 +1,74 @@
+package carbonx
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestFetchArchives(t *testing.T) {
+	now := time.Unix(10002, 0)
+	retentions := []carbonzipperpb3.Retention{
+		{SecondsPerPoint: 1, NumberOfPoints: 20},
+		{SecondsPerPoint: 5, NumberOfPoints: 12},
+		{SecondsPerPoint: 15, NumberOfPoints: 8},
+	}
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case infoEndpoint:
+			writeProtobuf(w, &carbonzipperpb3.InfoResponse{Name: "a.b", Retentions: retentions})
+		case renderEndpoint:
+			from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 32)
+			until, _ := strconv.ParseInt(r.URL.Query().Get("until"), 10, 32)
+			if until > now.Unix() {
+				until = now.Unix()
+			}
+			// Choose the archive and the intervals like whisper does.
+			var step int64
+			for _, ret := range retentions {
+				step = int64(ret.SecondsPerPoint)
+				if int64(ret.NumberOfPoints)*step >= now.Unix()-from {
+					break
+				}
+			}
+			fromInterval := from - from%step + step
+			untilInterval := until - until%step + step
+			if fromInterval == untilInterval {
+				untilInterval += step
+			}
+			writeProtobuf(w, &carbonzipperpb3.MultiFetchResponse{
+				Metrics: []carbonzipperpb3.FetchResponse{
+					{Name: "a.b", StartTime: int32(fromInterval), StopTime: int32(untilInterval), StepTime: int32(step)},
+				},
+			})
+		}
+	}))
+	defer ts.Close()
+
+	archives, err := c.FetchArchives("a.b", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start, stop, step int32
+	}{
+		{9985, 10003, 1},
+		{9960, 9985, 5},
+		{9915, 9960, 15},
+	}
+	if len(archives) != len(want) {
+		t.Fatalf("unexpected archive count, got=%d, want=%d", len(archives), len(want))
+	}
+	for i, a := range archives {
+		if a.Retention != retentions[i] {
+			t.Errorf("archive %d: unexpected retention, got=%+v, want=%+v", i, a.Retention, retentions[i])
+		}
+		if a.Data.StartTime != want[i].start || a.Data.StopTime != want[i].stop || a.Data.StepTime != want[i].step {
+			t.Errorf("archive %d: unexpected data range, got=%d-%d step %d, want=%d-%d step %d", i,
+				a.Data.StartTime, a.Data.StopTime, a.Data.StepTime, want[i].start, want[i].stop, want[i].step)
+		}
+	}
+}
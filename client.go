@@ -1,11 +1,15 @@
 package carbonx
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hnakamur/carbonx/carbonzipperpb3"
@@ -13,71 +17,188 @@ import (
 
 var ErrNotFound = errors.New("not found")
 var SkipDir = errors.New("skip this directory")
+var SkipAll = errors.New("skip everything and stop the walk")
+
+const (
+	findEndpoint    = "/metrics/find/"
+	infoEndpoint    = "/info/"
+	renderEndpoint  = "/render/"
+	listEndpoint    = "/metrics/list/"
+	detailsEndpoint = "/metrics/details/"
+)
 
 type Client struct {
 	serverURL  *url.URL
 	httpClient *http.Client
+	decorators []RequestDecorator
+
+	retryPolicy     RetryPolicy
+	maxResponseSize int64
+	findCache       *FindCache
 }
 
-func NewClient(serverURL string, httpClient *http.Client) (*Client, error) {
+type ClientOption func(c *Client)
+
+func NewClient(serverURL string, httpClient *http.Client, opts ...ClientOption) (*Client, error) {
 	u, err := url.Parse(serverURL)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{
+	c := &Client{
 		serverURL:  u,
 		httpClient: httpClient,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.findCache != nil {
+		err = c.findCache.bind(c.cacheServer())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
-func (c *Client) FindMetrics(pattern string) (*carbonzipperpb3.GlobResponse, error) {
-	u := url.URL{
-		Scheme:   c.serverURL.Scheme,
-		Host:     c.serverURL.Host,
-		Path:     "/metrics/find/",
-		RawQuery: fmt.Sprintf("format=protobuf&query=%s", url.QueryEscape(pattern)),
+// cacheServer returns the server URL without the userinfo, which identifies
+// the server for the FindCache.
+func (c *Client) cacheServer() string {
+	u := *c.serverURL
+	u.User = nil
+	return u.String()
+}
+
+// ServerURL returns the server URL passed to NewClient with the password
+// redacted.
+func (c *Client) ServerURL() string {
+	return c.serverURL.Redacted()
+}
+
+// endpointURL returns the URL for endpoint under the path of the server URL
+// passed to NewClient. The userinfo and the query parameters of the server
+// URL are kept, and query parameters in query take precedence over them.
+func (c *Client) endpointURL(endpoint string, query url.Values) *url.URL {
+	u := *c.serverURL
+	u.Path = strings.TrimSuffix(c.serverURL.Path, "/") + endpoint
+	u.RawPath = ""
+	u.Fragment = ""
+
+	q := c.serverURL.Query()
+	for k, v := range query {
+		q[k] = v
 	}
-	resp, err := c.httpClient.Get(u.String())
+	u.RawQuery = q.Encode()
+	return &u
+}
+
+// open sends a request to endpoint and returns the response body if the
+// status is 200 OK. The body is limited to the maximum response size of c.
+func (c *Client) open(ctx context.Context, endpoint string, query url.Values) (io.ReadCloser, error) {
+	u := c.endpointURL(endpoint, query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
+	for _, decorate := range c.decorators {
+		err = decorate(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		info := &carbonzipperpb3.GlobResponse{}
-		err = info.Unmarshal(data)
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPErrorBodyLen))
 		if err != nil {
 			return nil, err
 		}
-		return info, nil
-	case http.StatusNotFound:
-		return nil, ErrNotFound
-	default:
-		return nil, errors.New("unexpected status from /metrics/find/")
+		return nil, newHTTPError(endpoint, u, resp.StatusCode, data)
+	}
+	if c.maxResponseSize > 0 && resp.ContentLength > c.maxResponseSize {
+		resp.Body.Close()
+		return nil, &ResponseTooLargeError{Endpoint: endpoint, Limit: c.maxResponseSize}
+	}
+	return newResponseBody(resp.Body, endpoint, c.maxResponseSize), nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, query url.Values) ([]byte, error) {
+	body, err := c.open(ctx, endpoint, query)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+func (c *Client) FindMetrics(pattern string) (*carbonzipperpb3.GlobResponse, error) {
+	return c.FindMetricsContext(context.Background(), pattern)
+}
+
+func (c *Client) FindMetricsContext(ctx context.Context, pattern string) (*carbonzipperpb3.GlobResponse, error) {
+	if c.findCache != nil {
+		if info, ok := c.findCache.Get(pattern); ok {
+			return info, nil
+		}
+	}
+
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	query.Set("query", pattern)
+	info := &carbonzipperpb3.GlobResponse{}
+	err := c.retry(ctx, func() error {
+		data, err := c.get(ctx, findEndpoint, query)
+		if err != nil {
+			return err
+		}
+		info.Reset()
+		return info.Unmarshal(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.findCache != nil {
+		c.findCache.Put(pattern, info)
 	}
+	return info, nil
 }
 
 type FindMetricFunc func(name string, isLeaf bool, err error) error
 
 func (c *Client) FindMetricsRecursive(name string, findMetricFn FindMetricFunc) error {
-	var pattern string
-	if name == "" {
-		pattern = "*"
-	} else {
-		pattern = name + ".*"
+	return c.FindMetricsRecursiveContext(context.Background(), name, findMetricFn)
+}
+
+// FindMetricsRecursiveContext is like FindMetricsRecursive but stops the walk
+// and returns ctx.Err() as soon as ctx is done.
+func (c *Client) FindMetricsRecursiveContext(ctx context.Context, name string, findMetricFn FindMetricFunc) error {
+	err := c.findMetricsRecursive(ctx, name, findMetricFn)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) findMetricsRecursive(ctx context.Context, name string, findMetricFn FindMetricFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	resp, err := c.FindMetrics(pattern)
+	resp, err := c.FindMetricsContext(ctx, childPattern(name))
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return findMetricFn(name, false, err)
 	}
 	for _, m := range resp.Matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		err = findMetricFn(m.Path, m.IsLeaf, nil)
 		if err != nil {
 			if err == SkipDir {
@@ -86,7 +207,7 @@ func (c *Client) FindMetricsRecursive(name string, findMetricFn FindMetricFunc)
 			return err
 		}
 		if !m.IsLeaf {
-			err = c.FindMetricsRecursive(m.Path, findMetricFn)
+			err = c.findMetricsRecursive(ctx, m.Path, findMetricFn)
 			if err != nil {
 				return err
 			}
@@ -96,74 +217,162 @@ func (c *Client) FindMetricsRecursive(name string, findMetricFn FindMetricFunc)
 }
 
 func (c *Client) GetMetricInfo(name string) (*carbonzipperpb3.InfoResponse, error) {
-	u := url.URL{
-		Scheme:   c.serverURL.Scheme,
-		Host:     c.serverURL.Host,
-		Path:     "/info/",
-		RawQuery: fmt.Sprintf("format=protobuf&target=%s", url.QueryEscape(name)),
+	return c.GetMetricInfoContext(context.Background(), name)
+}
+
+func (c *Client) GetMetricInfoContext(ctx context.Context, name string) (*carbonzipperpb3.InfoResponse, error) {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	query.Set("target", name)
+	info := &carbonzipperpb3.InfoResponse{}
+	err := c.retry(ctx, func() error {
+		data, err := c.get(ctx, infoEndpoint, query)
+		if err != nil {
+			return err
+		}
+		info.Reset()
+		return info.Unmarshal(data)
+	})
+	if err != nil {
+		return nil, err
 	}
-	resp, err := c.httpClient.Get(u.String())
+	return info, nil
+}
+
+// ListMetrics returns the names of all the metrics on the server.
+func (c *Client) ListMetrics() (*carbonzipperpb3.ListMetricsResponse, error) {
+	return c.ListMetricsContext(context.Background())
+}
+
+func (c *Client) ListMetricsContext(ctx context.Context) (*carbonzipperpb3.ListMetricsResponse, error) {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	list := &carbonzipperpb3.ListMetricsResponse{}
+	err := c.retry(ctx, func() error {
+		data, err := c.get(ctx, listEndpoint, query)
+		if err != nil {
+			return err
+		}
+		list.Reset()
+		return list.Unmarshal(data)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return list, nil
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
+func (c *Client) FetchData(name string, from, until time.Time) (*carbonzipperpb3.FetchResponse, error) {
+	return c.FetchDataContext(context.Background(), name, from, until)
+}
+
+func (c *Client) FetchDataContext(ctx context.Context, name string, from, until time.Time) (*carbonzipperpb3.FetchResponse, error) {
+	var metrics []carbonzipperpb3.FetchResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		metrics, err = c.render(ctx, []string{name}, from, until)
+		if err != nil {
+			return err
+		}
+		if len(metrics) == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	if len(metrics) != 1 {
+		return nil, fmt.Errorf("unexpected metrics count in MultiFetchResponse, len(metrics)=%d", len(metrics))
+	}
+	return &metrics[0], nil
+}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		info := &carbonzipperpb3.InfoResponse{}
-		err = info.Unmarshal(data)
+// FetchMultiData fetches all the series matched by targets in one request.
+// Each target may be a metric name or a glob pattern such as servers.*.cpu.
+// The result is keyed by the series name. A series returned more than once,
+// for example for overlapping patterns, is kept once if the copies are the
+// same, and an error is returned if they differ.
+func (c *Client) FetchMultiData(targets []string, from, until time.Time) (map[string]*carbonzipperpb3.FetchResponse, error) {
+	return c.FetchMultiDataContext(context.Background(), targets, from, until)
+}
+
+func (c *Client) FetchMultiDataContext(ctx context.Context, targets []string, from, until time.Time) (map[string]*carbonzipperpb3.FetchResponse, error) {
+	var metrics []carbonzipperpb3.FetchResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		metrics, err = c.render(ctx, targets, from, until)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		return info, nil
-	case http.StatusNotFound:
-		return nil, ErrNotFound
-	default:
-		return nil, errors.New("unexpected status from info")
+		if len(metrics) == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*carbonzipperpb3.FetchResponse, len(metrics))
+	for i := range metrics {
+		r := &metrics[i]
+		if prev, ok := m[r.Name]; ok {
+			if prev.String() != r.String() {
+				return nil, fmt.Errorf("different series with the same name in MultiFetchResponse, name=%s", r.Name)
+			}
+			continue
+		}
+		m[r.Name] = r
 	}
+	return m, nil
 }
 
-func (c *Client) FetchData(name string, from, until time.Time) (*carbonzipperpb3.FetchResponse, error) {
-	u := url.URL{
-		Scheme: c.serverURL.Scheme,
-		Host:   c.serverURL.Host,
-		Path:   "/render/",
-		RawQuery: fmt.Sprintf("format=protobuf&target=%s&from=%d&until=%d",
-			name, from.Unix(), until.Unix()),
-	}
-	resp, err := c.httpClient.Get(u.String())
+// Render sends targets to /render/ and returns the resulting series in the
+// order the server returned them. A target can be any Graphite target
+// expression, for example sumSeries(servers.*.cpu) for carbonapi, or a
+// tagged series. Targets are escaped, so they may contain characters like
+// '&', '+', ';' or spaces.
+func (c *Client) Render(targets []string, from, until time.Time) ([]carbonzipperpb3.FetchResponse, error) {
+	return c.RenderContext(context.Background(), targets, from, until)
+}
+
+func (c *Client) RenderContext(ctx context.Context, targets []string, from, until time.Time) ([]carbonzipperpb3.FetchResponse, error) {
+	var metrics []carbonzipperpb3.FetchResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		metrics, err = c.render(ctx, targets, from, until)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return metrics, nil
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
+func (c *Client) render(ctx context.Context, targets []string, from, until time.Time) ([]carbonzipperpb3.FetchResponse, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no targets to render")
+	}
+	data, err := c.get(ctx, renderEndpoint, renderQuery(targets, from, until))
 	if err != nil {
 		return nil, err
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		result := &carbonzipperpb3.MultiFetchResponse{}
-		err = result.Unmarshal(data)
-		if err != nil {
-			return nil, err
-		}
-		metrics := result.GetMetrics()
-		if len(metrics) == 0 {
-			return nil, ErrNotFound
-		} else if len(metrics) != 1 {
-			return nil, fmt.Errorf("unexpected metrics count in MultiFetchResponse, len(metrics)=%d", len(metrics))
-		}
-		return &metrics[0], nil
-	case http.StatusNotFound:
-		return nil, ErrNotFound
-	default:
-		return nil, errors.New("unexpected status from info")
+	result := &carbonzipperpb3.MultiFetchResponse{}
+	err = result.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return result.GetMetrics(), nil
+}
+
+func renderQuery(targets []string, from, until time.Time) url.Values {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	for _, target := range targets {
+		query.Add("target", target)
 	}
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	return query
 }
@@ -1,13 +1,17 @@
 package carbonx
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,7 +21,6 @@ import (
 	"github.com/hnakamur/carbonx/testserver"
 	"github.com/hnakamur/freeport"
 	"github.com/hnakamur/netutil"
-	retry "github.com/rafaeljesus/retry-go"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
@@ -113,6 +116,294 @@ func TestSendProtobuf(t *testing.T) {
 	ts.Wait()
 }
 
+func TestRollup(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "carbontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	ts, err := startCarbonServer(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer ts.Kill()
+
+		// go-carbon writes the points a little after they are sent, so
+		// the points are kept away from the archive boundaries at the
+		// ages of 5s, 15s and 60s. Several points fall in the same slot
+		// of each archive.
+		now := time.Now().Truncate(time.Second)
+		m := &carbonpb.Metric{Metric: "test.rollup"}
+		for i, age := range []int{36, 33, 30, 27, 24, 21, 10, 9, 8, 7} {
+			m.Points = append(m.Points, carbonpb.Point{
+				Timestamp: uint32(now.Add(-time.Duration(age) * time.Second).Unix()),
+				Value:     float64(i + 1),
+			})
+		}
+
+		s, err := sender.NewTCPSender(
+			convertListenToConnect(ts.ProtobufListen),
+			sender.NewProtobuf3MetricsMarshaler())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = s.ConnectSendClose([]*carbonpb.Metric{m})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		archives, err := testserver.SimulateRollup(ts.Schemas[0], ts.Aggregations[0], m, now)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		u := url.URL{Scheme: "http", Host: convertListenToConnect(ts.CarbonserverListen)}
+		c, err := NewClient(
+			u.String(),
+			&http.Client{Timeout: 5 * time.Second},
+			WithRetryPolicy(RetryPolicy{
+				Attempts:       5,
+				InitialBackoff: 100 * time.Millisecond,
+				Multiplier:     1,
+				RetryNotFound:  true,
+			}))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Fetch the 5s and 15s archives by asking for periods which the
+		// higher precision archives do not cover even if the server's
+		// clock is a few seconds ahead.
+		for _, f := range []struct {
+			archive int
+			period  time.Duration
+		}{
+			{1, 10 * time.Second},
+			{2, 40 * time.Second},
+		} {
+			a := archives[f.archive]
+			data, err := c.FetchData(m.Metric, now.Add(-f.period), now)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if data.StepTime != int32(a.Retention.SecondsPerPoint) {
+				t.Errorf("unexpected step, got=%d, want=%d", data.StepTime, a.Retention.SecondsPerPoint)
+				continue
+			}
+			want := &carbonpb.Metric{Metric: m.Metric}
+			for _, p := range a.Points {
+				if int32(p.Timestamp) >= data.StartTime && int32(p.Timestamp) < data.StopTime {
+					want.Points = append(want.Points, p)
+				}
+			}
+			got := formatMetric(convertFetchResponseToMetric(data))
+			if got != formatMetric(want) {
+				t.Errorf("TestRollup: unexpected %ds archive,\ngot =%s,\nwant=%s,\ndiff=%s",
+					a.Retention.SecondsPerPoint, got, formatMetric(want), diff(got, formatMetric(want)))
+			}
+		}
+	}()
+	ts.Wait()
+}
+
+func TestFindMetricsRecursiveContextCancel(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b.c", "a.b.d", "a.e", "f.g"},
+	})
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var names []string
+	err := c.FindMetricsRecursiveContext(ctx, "", func(name string, isLeaf bool, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		if name == "a.b" {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("unexpected error, got=%v, want=%v", err, context.Canceled)
+	}
+	if got, want := strings.Join(names, ","), "a,a.b"; got != want {
+		t.Errorf("unexpected names, got=%s, want=%s", got, want)
+	}
+}
+
+func TestFetchDataContextDeadline(t *testing.T) {
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	now := time.Now()
+	_, err := c.FetchDataContext(ctx, "a.b", now.Add(-time.Minute), now)
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got err=%v, ctx.Err()=%v", err, ctx.Err())
+	}
+}
+
+func TestFetchMultiData(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{
+		series: []carbonzipperpb3.FetchResponse{
+			{Name: "servers.a.cpu", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{1}, IsAbsent: []bool{false}},
+			{Name: "servers.b.cpu", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{2}, IsAbsent: []bool{false}},
+			{Name: "servers.b.mem", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{3}, IsAbsent: []bool{false}},
+			{Name: "servers.c.mem", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{4}, IsAbsent: []bool{false}},
+		},
+	})
+	defer ts.Close()
+
+	got, err := c.FetchMultiData([]string{"servers.*.cpu", "servers.c.mem", "servers.a.cpu"}, time.Unix(60, 0), time.Unix(120, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"servers.a.cpu": 1, "servers.b.cpu": 2, "servers.c.mem": 4}
+	if len(got) != len(want) {
+		t.Errorf("unexpected series count, got=%d, want=%d", len(got), len(want))
+	}
+	for name, v := range want {
+		r, ok := got[name]
+		if !ok {
+			t.Errorf("series %s not found", name)
+			continue
+		}
+		if r.Values[0] != v {
+			t.Errorf("unexpected value for %s, got=%g, want=%g", name, r.Values[0], v)
+		}
+	}
+
+	_, err = c.FetchMultiData([]string{"no.such.metric"}, time.Unix(60, 0), time.Unix(120, 0))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	c, ts2 := newFakeClient(t, &fakeCarbonserver{
+		series: []carbonzipperpb3.FetchResponse{
+			{Name: "servers.a.cpu", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{1}, IsAbsent: []bool{false}},
+			{Name: "servers.a.cpu", StartTime: 60, StopTime: 120, StepTime: 60, Values: []float64{5}, IsAbsent: []bool{false}},
+		},
+	})
+	defer ts2.Close()
+	_, err = c.FetchMultiData([]string{"servers.a.cpu"}, time.Unix(60, 0), time.Unix(120, 0))
+	if err == nil {
+		t.Errorf("expected error for different series with the same name")
+	}
+}
+
+func TestRenderEscapesTargets(t *testing.T) {
+	var gotTargets []string
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTargets = r.URL.Query()["target"]
+		var resp carbonzipperpb3.MultiFetchResponse
+		for _, target := range gotTargets {
+			resp.Metrics = append(resp.Metrics, carbonzipperpb3.FetchResponse{Name: target})
+		}
+		writeProtobuf(w, &resp)
+	}))
+	defer ts.Close()
+
+	targets := []string{
+		"sumSeries(a.*)",
+		"alias(a.b, 'x & y + z; w')",
+		"seriesByTag('name=cpu', 'dc=a b')",
+	}
+	got, err := c.Render(targets, time.Unix(60, 0), time.Unix(120, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(gotTargets, "\n") != strings.Join(targets, "\n") {
+		t.Errorf("unexpected targets received, got=%q, want=%q", gotTargets, targets)
+	}
+	for i, r := range got {
+		if r.Name != targets[i] {
+			t.Errorf("unexpected series name, got=%q, want=%q", r.Name, targets[i])
+		}
+	}
+
+	r, err := c.FetchData("a.b&c=d", time.Unix(60, 0), time.Unix(120, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "a.b&c=d" {
+		t.Errorf("unexpected series name, got=%q, want=%q", r.Name, "a.b&c=d")
+	}
+}
+
+func TestClientBasePath(t *testing.T) {
+	var gotPath, gotUser, gotPassword string
+	var gotQuery url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.Query()
+		gotUser, gotPassword, _ = r.BasicAuth()
+		writeProtobuf(w, &carbonzipperpb3.InfoResponse{Name: r.URL.Query().Get("target")})
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword("user", "secret")
+	u.Path = "/graphite/"
+	u.RawQuery = "tenant=t1&format=json"
+	c, err := NewClient(u.String(), &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetMetricInfo("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/graphite/info/" {
+		t.Errorf("unexpected path, got=%s, want=%s", gotPath, "/graphite/info/")
+	}
+	if gotUser != "user" || gotPassword != "secret" {
+		t.Errorf("unexpected credentials, user=%s, password=%s", gotUser, gotPassword)
+	}
+	if gotQuery.Get("tenant") != "t1" || gotQuery.Get("format") != "protobuf" || gotQuery.Get("target") != "a.b" {
+		t.Errorf("unexpected query, got=%v", gotQuery)
+	}
+}
+
+func TestListMetrics(t *testing.T) {
+	leaves := []string{"a.b.c", "a.b.d", "a.e", "f.g"}
+	c, ts := newFakeClient(t, &fakeCarbonserver{leaves: leaves})
+	defer ts.Close()
+
+	list, err := c.ListMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(list.Metrics, ","), strings.Join(leaves, ","); got != want {
+		t.Errorf("unexpected metrics, got=%s, want=%s", got, want)
+	}
+
+	var names []string
+	err = c.ListMetricsStream(func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names, ","), strings.Join(leaves, ","); got != want {
+		t.Errorf("unexpected streamed metrics, got=%s, want=%s", got, want)
+	}
+}
+
 func startCarbonServer(rootDir string) (*testserver.Carbon, error) {
 	ports, err := freeport.GetFreePorts(3)
 	if err != nil {
@@ -174,24 +465,22 @@ func fetchAndVerifyMetrics(t *testing.T, testName string, carbonserverListen str
 	u := url.URL{Scheme: "http", Host: convertListenToConnect(carbonserverListen)}
 	c, err := NewClient(
 		u.String(),
-		&http.Client{Timeout: 5 * time.Second})
+		&http.Client{Timeout: 5 * time.Second},
+		WithRetryPolicy(RetryPolicy{
+			Attempts:       5,
+			InitialBackoff: 100 * time.Millisecond,
+			Multiplier:     1,
+			RetryNotFound:  true,
+		}))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	for i, m := range metrics {
-		var info *carbonzipperpb3.InfoResponse
-		attempts := 5
-		sleepTime := 100 * time.Millisecond
-		err = retry.Do(func() error {
-			var err error
-			info, err = c.GetMetricInfo(m.Metric)
-			return err
-		}, attempts, sleepTime)
+		_, err := c.GetMetricInfo(m.Metric)
 		if err != nil {
 			t.Fatal(err)
 		}
-		//log.Printf("%s metricInfo=%+v", testName, info)
 
 		from := now.Add(-step)
 		until := from
@@ -0,0 +1,126 @@
+// Command carbonx-diff compares the data of metric subtrees on two
+// carbonservers.
+//
+// Usage:
+//
+//	carbonx-diff -a-url http://old:8080 -b-url http://new:8080 [flags] subtree...
+//
+// For each metric which differs, it reports the points missing on either
+// side, the points whose values differ by more than -tolerance, and the
+// differences of the retentions, aggregation method and xFilesFactor. It
+// exits with status 1 if any difference is found.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/hnakamur/carbonx"
+	"github.com/hnakamur/carbonx/internal/cmdutil"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	aURL := flag.String("a-url", "", "carbonserver URL of side A")
+	bURL := flag.String("b-url", "", "carbonserver URL of side B")
+	from := flag.String("from", "24h", "start of the time range, in unix time, RFC 3339 or a duration before now")
+	until := flag.String("until", "0s", "end of the time range, in unix time, RFC 3339 or a duration before now")
+	tolerance := flag.Float64("tolerance", 0, "maximum absolute difference of values considered equal")
+	concurrency := flag.Int("concurrency", 4, "number of metrics compared in parallel")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of requests to carbonservers")
+	flag.Parse()
+
+	if *aURL == "" || *bURL == "" {
+		log.Fatal("-a-url and -b-url are required")
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("no subtrees specified")
+	}
+
+	now := time.Now()
+	fromTime, err := cmdutil.ParseTime(*from, now)
+	if err != nil {
+		log.Fatalf("invalid -from: %s", err)
+	}
+	untilTime, err := cmdutil.ParseTime(*until, now)
+	if err != nil {
+		log.Fatalf("invalid -until: %s", err)
+	}
+
+	httpClient := &http.Client{Timeout: *timeout}
+	a, err := carbonx.NewClient(*aURL, httpClient)
+	if err != nil {
+		log.Fatalf("invalid -a-url: %s", err)
+	}
+	b, err := carbonx.NewClient(*bURL, httpClient)
+	if err != nil {
+		log.Fatalf("invalid -b-url: %s", err)
+	}
+	d := &carbonx.SeriesDiffer{
+		A:           a,
+		B:           b,
+		From:        fromTime,
+		Until:       untilTime,
+		Tolerance:   *tolerance,
+		Concurrency: *concurrency,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt)
+	go func() {
+		<-sigC
+		cancel()
+	}()
+
+	var metrics, diffs int
+	for _, root := range flag.Args() {
+		report, err := d.Diff(ctx, root)
+		if report != nil {
+			metrics += report.Metrics
+			diffs += len(report.Diffs)
+			for _, diff := range report.Diffs {
+				writeDiff(os.Stdout, diff)
+			}
+		}
+		if err != nil {
+			log.Printf("%s: %s", root, err)
+			return 2
+		}
+	}
+	fmt.Printf("metrics: %d, differing metrics: %d\n", metrics, diffs)
+	if diffs > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeDiff(w io.Writer, d *carbonx.MetricDiff) {
+	fmt.Fprintf(w, "%s:\n", d.Name)
+	if d.Err != nil {
+		fmt.Fprintf(w, "  error: %s\n", d.Err)
+	}
+	for _, m := range d.InfoMismatches {
+		fmt.Fprintf(w, "  info: %s\n", m)
+	}
+	if len(d.MissingInA) > 0 {
+		fmt.Fprintf(w, "  missing in A: %d points %v\n", len(d.MissingInA), d.MissingInA)
+	}
+	if len(d.MissingInB) > 0 {
+		fmt.Fprintf(w, "  missing in B: %d points %v\n", len(d.MissingInB), d.MissingInB)
+	}
+	for _, p := range d.Differing {
+		fmt.Fprintf(w, "  differs at %d: A=%g, B=%g\n", p.Timestamp, p.A, p.B)
+	}
+}
@@ -0,0 +1,221 @@
+// Command carbonx-migrate copies or merges metric subtrees from one
+// carbonserver to another.
+//
+// Usage:
+//
+//	carbonx-migrate -src-url http://src:8080 -dest-url http://dest:8080 \
+//		-dest-receiver dest:2003 [flags] subtree...
+//
+// The data is read from the carbonserver at -src-url, compared with the data
+// in the carbonserver at -dest-url and written to the carbon receiver at
+// -dest-receiver.
+//
+// Metrics can be copied under new names with -rename and -rename-regexp,
+// which can be specified more than once. For example,
+//
+//	-rename 'app.*.*=service.app.$1.$2'
+//
+// copies app.host1.cpu to service.app.host1.cpu.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/carbonx"
+	"github.com/hnakamur/carbonx/internal/cmdutil"
+	"github.com/hnakamur/carbonx/sender"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	srcURL := flag.String("src-url", "", "source carbonserver URL")
+	destURL := flag.String("dest-url", "", "destination carbonserver URL")
+	destReceiver := flag.String("dest-receiver", "", "destination carbon receiver address in host:port")
+	protocol := flag.String("protocol", "text", `protocol of the destination receiver, "text" or "protobuf"`)
+	mode := flag.String("mode", "merge", `"merge" to write only points absent in the destination, "overwrite" to also write points whose values differ`)
+	from := flag.String("from", "24h", "start of the time range, in unix time, RFC 3339 or a duration before now")
+	until := flag.String("until", "0s", "end of the time range, in unix time, RFC 3339 or a duration before now")
+	concurrency := flag.Int("concurrency", 4, "number of metrics migrated in parallel")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of requests to carbonservers")
+	dryRun := flag.Bool("dry-run", false, "compute the points to write without writing them")
+	reportFile := flag.String("report", "", "file to write the summary report to (default stdout)")
+	verbose := flag.Bool("verbose", false, "log the progress of each metric")
+	var rules renameFlag
+	flag.Var(rules.glob(), "rename", "rename rule in the form glob=template, where each wildcard in the glob is captured as $1, $2, ...")
+	flag.Var(rules.regexp(), "rename-regexp", "rename rule in the form regexp=template")
+	flag.Parse()
+
+	if *srcURL == "" || *destURL == "" || (*destReceiver == "" && !*dryRun) {
+		log.Fatal("-src-url, -dest-url and -dest-receiver are required")
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("no subtrees specified")
+	}
+
+	now := time.Now()
+	fromTime, err := cmdutil.ParseTime(*from, now)
+	if err != nil {
+		log.Fatalf("invalid -from: %s", err)
+	}
+	untilTime, err := cmdutil.ParseTime(*until, now)
+	if err != nil {
+		log.Fatalf("invalid -until: %s", err)
+	}
+
+	m := &carbonx.Migrator{
+		From:        fromTime,
+		Until:       untilTime,
+		Concurrency: *concurrency,
+		Rename:      rules.rules,
+		DryRun:      *dryRun,
+	}
+	switch *mode {
+	case "merge":
+		m.Mode = carbonx.MigrateMerge
+	case "overwrite":
+		m.Mode = carbonx.MigrateOverwrite
+	default:
+		log.Fatalf("invalid -mode %q", *mode)
+	}
+
+	httpClient := &http.Client{Timeout: *timeout}
+	m.Src, err = carbonx.NewClient(*srcURL, httpClient)
+	if err != nil {
+		log.Fatalf("invalid -src-url: %s", err)
+	}
+	m.Dest, err = carbonx.NewClient(*destURL, httpClient)
+	if err != nil {
+		log.Fatalf("invalid -dest-url: %s", err)
+	}
+
+	if !*dryRun {
+		var marshaler sender.MetricsMarshaler
+		switch *protocol {
+		case "text":
+			marshaler = sender.NewTextMetricsMarshaler()
+		case "protobuf":
+			marshaler = sender.NewProtobuf3MetricsMarshaler()
+		default:
+			log.Fatalf("invalid -protocol %q", *protocol)
+		}
+		s, err := sender.NewTCPSender(*destReceiver, marshaler)
+		if err != nil {
+			log.Fatalf("invalid -dest-receiver: %s", err)
+		}
+		err = s.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		m.Sender = s
+	}
+	if *verbose {
+		m.Progress = func(p carbonx.MigrateProgress) {
+			if p.Err != nil {
+				log.Printf("[%d/%d] %s: %s", p.Done, p.Total, p.Name, p.Err)
+			} else {
+				log.Printf("[%d/%d] %s: %d points", p.Done, p.Total, p.Name, p.Points)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt)
+	go func() {
+		<-sigC
+		cancel()
+	}()
+
+	report, err := m.Migrate(ctx, flag.Args()...)
+	if report == nil {
+		report = &carbonx.MigrateReport{}
+	}
+	if err != nil {
+		var rootErr *carbonx.MetricError
+		if !errors.As(err, &rootErr) {
+			rootErr = &carbonx.MetricError{Name: strings.Join(flag.Args(), " "), Err: err}
+		}
+		report.Errors = append(report.Errors, rootErr)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *reportFile != "" {
+		file, err := os.Create(*reportFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	writeReport(w, m, report)
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeReport(w io.Writer, m *carbonx.Migrator, r *carbonx.MigrateReport) {
+	fmt.Fprintf(w, "mode: %s\n", m.Mode)
+	fmt.Fprintf(w, "dry run: %v\n", m.DryRun)
+	fmt.Fprintf(w, "range: %s - %s\n", m.From.Format(time.RFC3339), m.Until.Format(time.RFC3339))
+	fmt.Fprintf(w, "metrics: %d\n", r.Metrics)
+	fmt.Fprintf(w, "metrics copied: %d\n", r.MetricsWritten)
+	fmt.Fprintf(w, "points written: %d\n", r.Points)
+	fmt.Fprintf(w, "errors: %d\n", len(r.Errors))
+	for _, err := range r.Errors {
+		fmt.Fprintf(w, "  %s\n", err)
+	}
+}
+
+// renameFlag collects the rename rules from -rename and -rename-regexp in
+// the order they are specified.
+type renameFlag struct {
+	rules []*carbonx.RenameRule
+}
+
+type renameFlagValue struct {
+	f      *renameFlag
+	newFn  func(pattern, template string) (*carbonx.RenameRule, error)
+	values []string
+}
+
+func (f *renameFlag) glob() *renameFlagValue {
+	return &renameFlagValue{f: f, newFn: carbonx.NewGlobRenameRule}
+}
+
+func (f *renameFlag) regexp() *renameFlagValue {
+	return &renameFlagValue{f: f, newFn: carbonx.NewRegexpRenameRule}
+}
+
+func (v *renameFlagValue) String() string {
+	return strings.Join(v.values, " ")
+}
+
+func (v *renameFlagValue) Set(s string) error {
+	i := strings.LastIndexByte(s, '=')
+	if i == -1 {
+		return fmt.Errorf("no '=' in rename rule %q", s)
+	}
+	rule, err := v.newFn(s[:i], s[i+1:])
+	if err != nil {
+		return err
+	}
+	v.f.rules = append(v.f.rules, rule)
+	v.values = append(v.values, s)
+	return nil
+}
@@ -0,0 +1,41 @@
+// Command carbonx-zipper is a small carbonzipper compatible proxy. It serves
+// /metrics/find/, /info/ and /render/ in the protobuf format by sending the
+// requests to all the configured carbonservers and merging the responses.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/carbonx"
+	"github.com/hnakamur/carbonx/zipper"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "listen address")
+	backends := flag.String("backends", "", "comma separated carbonserver URLs, e.g. http://carbon1:8080,http://carbon2:8080")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of requests to backends")
+	maxResponseSize := flag.Int64("max-response-size", 0, "maximum response size from a backend in bytes (0 means no limit)")
+	flag.Parse()
+
+	if *backends == "" {
+		log.Fatal("no backends specified")
+	}
+	httpClient := &http.Client{Timeout: *timeout}
+	var clients []*carbonx.Client
+	for _, u := range strings.Split(*backends, ",") {
+		c, err := carbonx.NewClient(strings.TrimSpace(u), httpClient,
+			carbonx.WithMaxResponseSize(*maxResponseSize))
+		if err != nil {
+			log.Fatalf("invalid backend URL %q: %s", u, err)
+		}
+		clients = append(clients, c)
+	}
+
+	h := zipper.NewHandler(carbonx.NewMultiClient(clients...))
+	log.Printf("listening on %s, backends=%s", *listen, *backends)
+	log.Fatal(http.ListenAndServe(*listen, h))
+}
@@ -2,6 +2,7 @@ package carbonx
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/hnakamur/carbonx/carbonpb"
 	"github.com/hnakamur/carbonx/carbonzipperpb3"
@@ -54,7 +55,7 @@ func convertFetchResponsesToMetricForOverwrite(src, dest *carbonzipperpb3.FetchR
 		Metric: dest.Name,
 	}
 	for i, v := range src.Values {
-		if src.IsAbsent[i] || (!dest.IsAbsent[i] && dest.Values[i] == v) {
+		if c := comparePoint(src, dest, i, 0); c != pointOnlyInSrc && c != pointDiffers {
 			continue
 		}
 		m.Points = append(m.Points, carbonpb.Point{
@@ -65,6 +66,33 @@ func convertFetchResponsesToMetricForOverwrite(src, dest *carbonzipperpb3.FetchR
 	return m, nil
 }
 
+type pointComparison int
+
+const (
+	pointSame pointComparison = iota
+	pointOnlyInSrc
+	pointOnlyInDest
+	pointDiffers
+)
+
+// comparePoint compares the i-th points of src and dest, which must have
+// the same StartTime, StopTime and StepTime. Two present values are the
+// same if they differ by at most tolerance. Two absent points are the same.
+func comparePoint(src, dest *carbonzipperpb3.FetchResponse, i int, tolerance float64) pointComparison {
+	switch {
+	case src.IsAbsent[i] && dest.IsAbsent[i]:
+		return pointSame
+	case dest.IsAbsent[i]:
+		return pointOnlyInSrc
+	case src.IsAbsent[i]:
+		return pointOnlyInDest
+	case src.Values[i] == dest.Values[i] || math.Abs(src.Values[i]-dest.Values[i]) <= tolerance:
+		return pointSame
+	default:
+		return pointDiffers
+	}
+}
+
 func ensureSameStartStopStepTime(src, dest *carbonzipperpb3.FetchResponse) error {
 	if src.StartTime != dest.StartTime {
 		return fmt.Errorf("StartTime unmatched, src.Name=%s, src.StartTime=%d, dest.Name=%s, dest.StartTime=%d", src.Name, src.StartTime, dest.Name, dest.StartTime)
@@ -77,3 +105,197 @@ func ensureSameStartStopStepTime(src, dest *carbonzipperpb3.FetchResponse) error
 	}
 	return nil
 }
+
+// mergeFetchResponses merges the responses for the same series from
+// replicas. The response with the finest StepTime and the fewest absent
+// points is copied, and its absent points are filled from the other
+// responses which have the same StartTime, StopTime and StepTime.
+func mergeFetchResponses(responses []*carbonzipperpb3.FetchResponse) *carbonzipperpb3.FetchResponse {
+	var base *carbonzipperpb3.FetchResponse
+	var baseAbsent int
+	for _, r := range responses {
+		absent := countAbsent(r)
+		if base == nil || r.StepTime < base.StepTime ||
+			(r.StepTime == base.StepTime && absent < baseAbsent) {
+			base = r
+			baseAbsent = absent
+		}
+	}
+	if base == nil {
+		return nil
+	}
+
+	m := &carbonzipperpb3.FetchResponse{
+		Name:      base.Name,
+		StartTime: base.StartTime,
+		StopTime:  base.StopTime,
+		StepTime:  base.StepTime,
+		Values:    append([]float64(nil), base.Values...),
+		IsAbsent:  append([]bool(nil), base.IsAbsent...),
+	}
+	for _, r := range responses {
+		if baseAbsent == 0 {
+			break
+		}
+		if r == base || ensureSameStartStopStepTime(r, m) != nil || len(r.Values) != len(m.Values) {
+			continue
+		}
+		for i := range m.Values {
+			if m.IsAbsent[i] && !r.IsAbsent[i] {
+				m.Values[i] = r.Values[i]
+				m.IsAbsent[i] = false
+				baseAbsent--
+			}
+		}
+	}
+	return m
+}
+
+func countAbsent(r *carbonzipperpb3.FetchResponse) int {
+	var n int
+	for _, absent := range r.IsAbsent {
+		if absent {
+			n++
+		}
+	}
+	return n
+}
+
+// alignFetchResponse returns src resampled onto the time slots of dest, or
+// src itself if they already have the same StartTime, StopTime and
+// StepTime. method is the whisper aggregation method of the destination.
+func alignFetchResponse(src, dest *carbonzipperpb3.FetchResponse, method string) (*carbonzipperpb3.FetchResponse, error) {
+	if ensureSameStartStopStepTime(src, dest) == nil && len(src.Values) == len(dest.Values) {
+		return src, nil
+	}
+	return resampleFetchResponse(src, dest.StartTime, dest.StepTime, len(dest.Values), method)
+}
+
+// resampleFetchResponse resamples src onto count slots of stepTime seconds
+// from startTime.
+//
+// When the step of src is smaller than or equal to stepTime, the present
+// points of src in each slot are consolidated with method, which is one of
+// the go-carbon aggregation methods average, avg_zero, sum, min, max and
+// last. avg_zero counts the absent points in the slot as zero. When the
+// step of src is larger, each slot takes the value of the src point which
+// covers the start of the slot, except for sum, where only the slot which
+// contains the timestamp of the src point takes its value so that the total
+// is not multiplied by the ratio of the steps.
+func resampleFetchResponse(src *carbonzipperpb3.FetchResponse, startTime, stepTime int32, count int, method string) (*carbonzipperpb3.FetchResponse, error) {
+	if stepTime <= 0 || src.StepTime <= 0 {
+		return nil, fmt.Errorf("invalid StepTime for resampling, src.Name=%s, src.StepTime=%d, stepTime=%d", src.Name, src.StepTime, stepTime)
+	}
+	consolidate, err := consolidateFunc(method)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &carbonzipperpb3.FetchResponse{
+		Name:      src.Name,
+		StartTime: startTime,
+		StopTime:  startTime + int32(count)*stepTime,
+		StepTime:  stepTime,
+		Values:    make([]float64, count),
+		IsAbsent:  make([]bool, count),
+	}
+	isPresent := func(i int32) bool {
+		return i >= 0 && int(i) < len(src.Values) && !src.IsAbsent[i]
+	}
+	var values []float64
+	for j := 0; j < count; j++ {
+		slotStart := startTime + int32(j)*stepTime
+		values = values[:0]
+		var present bool
+		switch {
+		case src.StepTime <= stepTime:
+			first := ceilDiv(slotStart-src.StartTime, src.StepTime)
+			last := ceilDiv(slotStart+stepTime-src.StartTime, src.StepTime)
+			for i := first; i < last; i++ {
+				if isPresent(i) {
+					values = append(values, src.Values[i])
+					present = true
+				} else if method == "avg_zero" {
+					values = append(values, 0)
+				}
+			}
+		case method == "sum":
+			i := ceilDiv(slotStart-src.StartTime, src.StepTime)
+			if src.StartTime+i*src.StepTime < slotStart+stepTime && isPresent(i) {
+				values = append(values, src.Values[i])
+				present = true
+			}
+		default:
+			i := floorDiv(slotStart-src.StartTime, src.StepTime)
+			if isPresent(i) {
+				values = append(values, src.Values[i])
+				present = true
+			}
+		}
+		if !present {
+			r.IsAbsent[j] = true
+			continue
+		}
+		r.Values[j] = consolidate(values)
+	}
+	return r, nil
+}
+
+func consolidateFunc(method string) (func(values []float64) float64, error) {
+	switch method {
+	case "average", "avg", "avg_zero":
+		return func(values []float64) float64 {
+			var sum float64
+			for _, v := range values {
+				sum += v
+			}
+			return sum / float64(len(values))
+		}, nil
+	case "sum":
+		return func(values []float64) float64 {
+			var sum float64
+			for _, v := range values {
+				sum += v
+			}
+			return sum
+		}, nil
+	case "min":
+		return func(values []float64) float64 {
+			m := values[0]
+			for _, v := range values[1:] {
+				if v < m {
+					m = v
+				}
+			}
+			return m
+		}, nil
+	case "max":
+		return func(values []float64) float64 {
+			m := values[0]
+			for _, v := range values[1:] {
+				if v > m {
+					m = v
+				}
+			}
+			return m
+		}, nil
+	case "last":
+		return func(values []float64) float64 {
+			return values[len(values)-1]
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported consolidation method %q", method)
+	}
+}
+
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func ceilDiv(a, b int32) int32 {
+	return -floorDiv(-a, b)
+}
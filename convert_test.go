@@ -0,0 +1,107 @@
+package carbonx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestResampleFetchResponse(t *testing.T) {
+	fine := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 100, StopTime: 190, StepTime: 10,
+		Values:   []float64{1, 2, 3, 4, 5, 0, 7, 8, 9},
+		IsAbsent: []bool{false, false, false, false, false, true, false, false, false}}
+
+	testCases := []struct {
+		method   string
+		values   []float64
+		isAbsent []bool
+	}{
+		{"average", []float64{1.5, 4, 7.5}, []bool{false, false, false}},
+		{"sum", []float64{3, 12, 15}, []bool{false, false, false}},
+		{"min", []float64{1, 3, 7}, []bool{false, false, false}},
+		{"max", []float64{2, 5, 8}, []bool{false, false, false}},
+		{"last", []float64{2, 5, 8}, []bool{false, false, false}},
+		{"avg_zero", []float64{1, 4, 5}, []bool{false, false, false}},
+	}
+	for _, tc := range testCases {
+		// Slots start at 90, 120 and 150, so the first slot only has
+		// the points at 100 and 110, and the point at 180 is dropped.
+		got, err := resampleFetchResponse(fine, 90, 30, 3, tc.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Values, tc.values) || !reflect.DeepEqual(got.IsAbsent, tc.isAbsent) {
+			t.Errorf("%s: unexpected result, values=%v, isAbsent=%v, want values=%v, isAbsent=%v",
+				tc.method, got.Values, got.IsAbsent, tc.values, tc.isAbsent)
+		}
+		if got.StartTime != 90 || got.StopTime != 180 || got.StepTime != 30 {
+			t.Errorf("%s: unexpected time range, got=%d-%d step %d", tc.method, got.StartTime, got.StopTime, got.StepTime)
+		}
+	}
+
+	coarse := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 180, StepTime: 60,
+		Values: []float64{1, 0}, IsAbsent: []bool{false, true}}
+	got, err := resampleFetchResponse(coarse, 90, 30, 4, "average")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{false, true, true, true}; !reflect.DeepEqual(got.IsAbsent, want) || got.Values[0] != 1 {
+		t.Errorf("unexpected upsampling result, values=%v, isAbsent=%v", got.Values, got.IsAbsent)
+	}
+
+	// Upsampling a sum must keep the total, so only the slot which
+	// contains the timestamp of each coarse point gets its value.
+	coarse = &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 240, StepTime: 60,
+		Values: []float64{6, 0, 9}, IsAbsent: []bool{false, true, false}}
+	for _, tc := range []struct {
+		startTime int32
+		values    []float64
+		isAbsent  []bool
+	}{
+		{60, []float64{6, 0, 0, 0, 0, 0, 9, 0, 0}, []bool{false, true, true, true, true, true, false, true, true}},
+		{50, []float64{6, 0, 0, 0, 0, 0, 9, 0, 0}, []bool{false, true, true, true, true, true, false, true, true}},
+	} {
+		got, err = resampleFetchResponse(coarse, tc.startTime, 20, 9, "sum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Values, tc.values) || !reflect.DeepEqual(got.IsAbsent, tc.isAbsent) {
+			t.Errorf("unexpected sum upsampling result from %d, values=%v, isAbsent=%v, want values=%v, isAbsent=%v",
+				tc.startTime, got.Values, got.IsAbsent, tc.values, tc.isAbsent)
+		}
+	}
+
+	if _, err := resampleFetchResponse(fine, 90, 30, 3, "median"); err == nil {
+		t.Errorf("expected error for unsupported method")
+	}
+}
+
+func TestConvertWithAlignment(t *testing.T) {
+	src := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 300, StepTime: 60,
+		Values: []float64{1, 2, 3, 4}, IsAbsent: []bool{false, false, false, false}}
+	dest := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 0, StopTime: 360, StepTime: 120,
+		Values: []float64{0, 10, 0}, IsAbsent: []bool{true, false, true}}
+
+	if _, err := convertFetchResponsesToMetricForMerge(src, dest); err == nil {
+		t.Fatalf("expected error for unaligned responses")
+	}
+	aligned, err := alignFetchResponse(src, dest, "max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := convertFetchResponsesToMetricForMerge(aligned, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatMetric(m), "Metric{Metric:a.b, Points:{Timestamp:0,Value:1}, {Timestamp:240,Value:4}}"; got != want {
+		t.Errorf("unexpected merge result,\ngot =%s,\nwant=%s", got, want)
+	}
+	m, err = convertFetchResponsesToMetricForOverwrite(aligned, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatMetric(m), "Metric{Metric:a.b, Points:{Timestamp:0,Value:1}, {Timestamp:120,Value:3}, {Timestamp:240,Value:4}}"; got != want {
+		t.Errorf("unexpected overwrite result,\ngot =%s,\nwant=%s", got, want)
+	}
+}
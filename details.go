@@ -0,0 +1,102 @@
+package carbonx
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// GetMetricDetails returns the size and the access times of all the metrics
+// on the server along with the free and total space of the data directory.
+func (c *Client) GetMetricDetails() (*carbonzipperpb3.MetricDetailsResponse, error) {
+	return c.GetMetricDetailsContext(context.Background())
+}
+
+func (c *Client) GetMetricDetailsContext(ctx context.Context) (*carbonzipperpb3.MetricDetailsResponse, error) {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	details := &carbonzipperpb3.MetricDetailsResponse{}
+	err := c.retry(ctx, func() error {
+		data, err := c.get(ctx, detailsEndpoint, query)
+		if err != nil {
+			return err
+		}
+		details.Reset()
+		return details.Unmarshal(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// SubtreeSizes returns the total size in bytes of the metrics in each
+// subtree whose name has depth components. For example, with depth 2 the
+// sizes of a.b.c and a.b.d are added up under a.b. Metrics with depth or
+// fewer components are counted under their own name.
+func SubtreeSizes(details *carbonzipperpb3.MetricDetailsResponse, depth int) map[string]int64 {
+	sizes := make(map[string]int64)
+	for name, d := range details.Metrics {
+		if d == nil {
+			continue
+		}
+		sizes[subtreeName(normalizeDetailsName(name), depth)] += d.Size_
+	}
+	return sizes
+}
+
+func subtreeName(name string, depth int) string {
+	if depth <= 0 {
+		return name
+	}
+	i := 0
+	for n := 0; n < depth; n++ {
+		j := strings.IndexByte(name[i:], '.')
+		if j == -1 {
+			return name
+		}
+		i += j + 1
+	}
+	return name[:i-1]
+}
+
+// NotReadSince returns the sorted names of the metrics whose RdTime is
+// before t.
+func NotReadSince(details *carbonzipperpb3.MetricDetailsResponse, t time.Time) []string {
+	return filterDetails(details, func(d *carbonzipperpb3.MetricDetails) bool {
+		return d.RdTime < t.Unix()
+	})
+}
+
+// NotModifiedSince returns the sorted names of the metrics whose ModTime is
+// before t.
+func NotModifiedSince(details *carbonzipperpb3.MetricDetailsResponse, t time.Time) []string {
+	return filterDetails(details, func(d *carbonzipperpb3.MetricDetails) bool {
+		return d.ModTime < t.Unix()
+	})
+}
+
+func filterDetails(details *carbonzipperpb3.MetricDetailsResponse, match func(d *carbonzipperpb3.MetricDetails) bool) []string {
+	var names []string
+	for name, d := range details.Metrics {
+		if d != nil && match(d) {
+			names = append(names, normalizeDetailsName(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeDetailsName converts a whisper file path like /a/b/c.wsp, which
+// some carbonserver versions use as the key, to the metric name a.b.c.
+func normalizeDetailsName(name string) string {
+	if !strings.ContainsRune(name, '/') {
+		return name
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "/"), ".wsp")
+	return strings.Replace(name, "/", ".", -1)
+}
@@ -0,0 +1,53 @@
+package carbonx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestMetricDetails(t *testing.T) {
+	details := &carbonzipperpb3.MetricDetailsResponse{
+		Metrics: map[string]*carbonzipperpb3.MetricDetails{
+			"a.b.c":     {Size_: 100, ModTime: 1000, RdTime: 3000},
+			"a.b.d":     {Size_: 200, ModTime: 3000, RdTime: 1000},
+			"/a/e.wsp":  {Size_: 400, ModTime: 3000, RdTime: 3000},
+			"f":         {Size_: 800, ModTime: 1000, RdTime: 1000},
+			"g.h.i.j.k": {Size_: 1600, ModTime: 3000, RdTime: 3000},
+		},
+		FreeSpace:  10000,
+		TotalSpace: 20000,
+	}
+	c, ts := newFakeClient(t, &fakeCarbonserver{details: details})
+	defer ts.Close()
+
+	got, err := c.GetMetricDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FreeSpace != details.FreeSpace || got.TotalSpace != details.TotalSpace || len(got.Metrics) != len(details.Metrics) {
+		t.Errorf("unexpected details, got=%+v", got)
+	}
+
+	sizes := SubtreeSizes(got, 2)
+	wantSizes := map[string]int64{"a.b": 300, "a.e": 400, "f": 800, "g.h": 1600}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("unexpected subtree sizes, got=%v, want=%v", sizes, wantSizes)
+	}
+	sizes = SubtreeSizes(got, 1)
+	wantSizes = map[string]int64{"a": 700, "f": 800, "g": 1600}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("unexpected subtree sizes, got=%v, want=%v", sizes, wantSizes)
+	}
+
+	threshold := time.Unix(2000, 0)
+	if got, want := strings.Join(NotReadSince(got, threshold), ","), "a.b.d,f"; got != want {
+		t.Errorf("unexpected not read metrics, got=%s, want=%s", got, want)
+	}
+	if got, want := strings.Join(NotModifiedSince(got, threshold), ","), "a.b.c,f"; got != want {
+		t.Errorf("unexpected not modified metrics, got=%s, want=%s", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// SeriesDiffer compares the data of metrics in the range [From, Until] on
+// two carbonservers A and B.
+type SeriesDiffer struct {
+	A, B        *Client
+	From, Until time.Time
+
+	// Tolerance is the maximum absolute difference of two values which
+	// are considered equal.
+	Tolerance float64
+
+	// Concurrency is the number of metrics compared in parallel. Values
+	// less than 1 mean 1.
+	Concurrency int
+}
+
+type PointDiff struct {
+	Timestamp uint32
+	A, B      float64
+}
+
+// MetricDiff is the difference of a metric between the two servers.
+type MetricDiff struct {
+	Name string
+
+	// MissingInA and MissingInB are the timestamps of the points which are
+	// present only in B and only in A respectively.
+	MissingInA []uint32
+	MissingInB []uint32
+	Differing  []PointDiff
+
+	// InfoMismatches describes the differences of the retentions,
+	// aggregation method and xFilesFactor of B from A.
+	InfoMismatches []string
+
+	// Err is the error which prevented the comparison.
+	Err error
+}
+
+func (d *MetricDiff) Equal() bool {
+	return d.Err == nil && len(d.MissingInA) == 0 && len(d.MissingInB) == 0 &&
+		len(d.Differing) == 0 && len(d.InfoMismatches) == 0
+}
+
+type DiffReport struct {
+	Metrics int
+
+	// Diffs are the differences of the metrics which are not equal, sorted
+	// by name.
+	Diffs []*MetricDiff
+}
+
+// Diff compares all the metrics under root, or root itself if it is a
+// metric, which exist on either server. It returns ErrNotFound if root
+// exists on neither server.
+func (d *SeriesDiffer) Diff(ctx context.Context, root string) (*DiffReport, error) {
+	namesA, errA := collectMetricNames(ctx, d.A, root, d.Concurrency)
+	if errA != nil && !errors.Is(errA, ErrNotFound) {
+		return nil, errA
+	}
+	namesB, errB := collectMetricNames(ctx, d.B, root, d.Concurrency)
+	if errB != nil && !errors.Is(errB, ErrNotFound) {
+		return nil, errB
+	}
+	if errA != nil && errB != nil {
+		return nil, ErrNotFound
+	}
+	names := unionNames(namesA, namesB)
+
+	report := &DiffReport{Metrics: len(names)}
+	var mu sync.Mutex
+	err := forEachName(ctx, names, d.Concurrency, func(name string) {
+		diff := d.DiffMetric(ctx, name)
+		if diff.Equal() {
+			return
+		}
+		mu.Lock()
+		report.Diffs = append(report.Diffs, diff)
+		mu.Unlock()
+	})
+	sort.Slice(report.Diffs, func(i, j int) bool {
+		return report.Diffs[i].Name < report.Diffs[j].Name
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// DiffMetric compares one metric.
+func (d *SeriesDiffer) DiffMetric(ctx context.Context, name string) *MetricDiff {
+	diff := &MetricDiff{Name: name}
+
+	infoA, err := d.A.GetMetricInfoContext(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		diff.Err = err
+		return diff
+	}
+	infoB, err := d.B.GetMetricInfoContext(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		diff.Err = err
+		return diff
+	}
+	if infoA != nil && infoB != nil {
+		diff.InfoMismatches = compareInfo(infoA, infoB)
+	}
+
+	a, err := d.A.FetchDataContext(ctx, name, d.From, d.Until)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		diff.Err = err
+		return diff
+	}
+	b, err := d.B.FetchDataContext(ctx, name, d.From, d.Until)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		diff.Err = err
+		return diff
+	}
+
+	switch {
+	case a == nil && b == nil:
+		diff.Err = ErrNotFound
+	case a == nil:
+		diff.MissingInA = presentTimestamps(b)
+	case b == nil:
+		diff.MissingInB = presentTimestamps(a)
+	default:
+		diff.Err = diffFetchResponses(diff, a, b, d.Tolerance)
+	}
+	return diff
+}
+
+func diffFetchResponses(diff *MetricDiff, a, b *carbonzipperpb3.FetchResponse, tolerance float64) error {
+	err := ensureSameStartStopStepTime(a, b)
+	if err != nil {
+		return err
+	}
+	if len(a.Values) != len(b.Values) {
+		return fmt.Errorf("values count unmatched, len(a)=%d, len(b)=%d", len(a.Values), len(b.Values))
+	}
+	for i := range a.Values {
+		ts := uint32(a.StartTime) + uint32(i)*uint32(a.StepTime)
+		switch comparePoint(a, b, i, tolerance) {
+		case pointOnlyInSrc:
+			diff.MissingInB = append(diff.MissingInB, ts)
+		case pointOnlyInDest:
+			diff.MissingInA = append(diff.MissingInA, ts)
+		case pointDiffers:
+			diff.Differing = append(diff.Differing, PointDiff{
+				Timestamp: ts,
+				A:         a.Values[i],
+				B:         b.Values[i],
+			})
+		}
+	}
+	return nil
+}
+
+func presentTimestamps(r *carbonzipperpb3.FetchResponse) []uint32 {
+	var timestamps []uint32
+	for i := range r.Values {
+		if !r.IsAbsent[i] {
+			timestamps = append(timestamps, uint32(r.StartTime)+uint32(i)*uint32(r.StepTime))
+		}
+	}
+	return timestamps
+}
+
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var names []string
+	for _, list := range [][]string{a, b} {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,80 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestSeriesDiffer(t *testing.T) {
+	a, ts1 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c", "a.d"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 2, 3}, []bool{false, false, false}),
+			fakeSeries("a.c", []float64{4, 5, 0}, []bool{false, false, true}),
+			fakeSeries("a.d", []float64{7, 8, 9}, []bool{false, false, false}),
+		},
+		infos: map[string]*carbonzipperpb3.InfoResponse{
+			"a.b": {Name: "a.b", AggregationMethod: "sum"},
+			"a.c": {Name: "a.c", AggregationMethod: "average"},
+		},
+	})
+	defer ts1.Close()
+	b, ts2 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c", "a.e"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1.0001, 2, 3}, []bool{false, false, false}),
+			fakeSeries("a.c", []float64{0, 5.5, 6}, []bool{true, false, false}),
+			fakeSeries("a.e", []float64{1, 0, 0}, []bool{false, true, true}),
+		},
+		infos: map[string]*carbonzipperpb3.InfoResponse{
+			"a.b": {Name: "a.b", AggregationMethod: "sum"},
+			"a.c": {Name: "a.c", AggregationMethod: "sum"},
+		},
+	})
+	defer ts2.Close()
+
+	d := &SeriesDiffer{
+		A:           a,
+		B:           b,
+		From:        time.Unix(60, 0),
+		Until:       time.Unix(240, 0),
+		Tolerance:   0.001,
+		Concurrency: 2,
+	}
+	report, err := d.Diff(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*MetricDiff{
+		{
+			Name:           "a.c",
+			MissingInA:     []uint32{180},
+			MissingInB:     []uint32{60},
+			Differing:      []PointDiff{{Timestamp: 120, A: 5, B: 5.5}},
+			InfoMismatches: []string{"aggregationMethod=sum, want=average"},
+		},
+		{Name: "a.d", MissingInB: []uint32{60, 120, 180}},
+		{Name: "a.e", MissingInA: []uint32{60}},
+	}
+	if report.Metrics != 4 {
+		t.Errorf("unexpected metrics count, got=%d, want=%d", report.Metrics, 4)
+	}
+	if !reflect.DeepEqual(report.Diffs, want) {
+		t.Errorf("unexpected diffs,\ngot =%+v,\nwant=%+v", report.Diffs, want)
+	}
+	report, err = d.Diff(context.Background(), "a.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Metrics != 1 || len(report.Diffs) != 1 {
+		t.Errorf("unexpected report for a root on one server, got=%+v", report)
+	}
+	if _, err := d.Diff(context.Background(), "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error for a missing root, got=%v, want=%v", err, ErrNotFound)
+	}
+}
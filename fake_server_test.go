@@ -0,0 +1,155 @@
+package carbonx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+type fakeCarbonserver struct {
+	leaves  []string
+	series  []carbonzipperpb3.FetchResponse
+	details *carbonzipperpb3.MetricDetailsResponse
+	infos   map[string]*carbonzipperpb3.InfoResponse
+}
+
+// fakeSeries returns a series with a 60 seconds step starting at 60.
+func fakeSeries(name string, values []float64, isAbsent []bool) carbonzipperpb3.FetchResponse {
+	return carbonzipperpb3.FetchResponse{Name: name, StartTime: 60, StopTime: 60 + 60*int32(len(values)), StepTime: 60,
+		Values: values, IsAbsent: isAbsent}
+}
+
+func (s *fakeCarbonserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/metrics/find/":
+		s.serveFind(w, r)
+	case "/render/":
+		s.serveRender(w, r)
+	case "/metrics/list/":
+		writeProtobuf(w, &carbonzipperpb3.ListMetricsResponse{Metrics: s.leaves})
+	case "/metrics/details/":
+		writeProtobuf(w, s.details)
+	case "/info/":
+		info, ok := s.infos[r.URL.Query().Get("target")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeProtobuf(w, info)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeCarbonserver) serveFind(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	patterns := strings.Split(query, ".")
+	resp := carbonzipperpb3.GlobResponse{Name: query}
+	seen := make(map[string]bool)
+	for _, leaf := range s.leaves {
+		names := strings.Split(leaf, ".")
+		if len(names) < len(patterns) || !matchGlobParts(patterns, names[:len(patterns)]) {
+			continue
+		}
+		name := strings.Join(names[:len(patterns)], ".")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		resp.Matches = append(resp.Matches, carbonzipperpb3.GlobMatch{Path: name, IsLeaf: len(names) == len(patterns)})
+	}
+	if len(resp.Matches) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeProtobuf(w, &resp)
+}
+
+func (s *fakeCarbonserver) serveRender(w http.ResponseWriter, r *http.Request) {
+	var resp carbonzipperpb3.MultiFetchResponse
+	for _, target := range r.URL.Query()["target"] {
+		for _, series := range s.series {
+			if matchGlob(target, series.Name) {
+				resp.Metrics = append(resp.Metrics, series)
+			}
+		}
+	}
+	if len(resp.Metrics) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeProtobuf(w, &resp)
+}
+
+// matchGlob matches name against a carbonserver glob pattern, where each
+// dot separated component is matched with path.Match after expanding
+// {a,b} alternatives.
+func matchGlob(pattern, name string) bool {
+	patterns := strings.Split(pattern, ".")
+	names := strings.Split(name, ".")
+	return len(patterns) == len(names) && matchGlobParts(patterns, names)
+}
+
+func matchGlobParts(patterns, names []string) bool {
+	for i := range patterns {
+		if !matchGlobPart(patterns[i], names[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchGlobPart(pattern, name string) bool {
+	for _, p := range expandBraces(pattern) {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func expandBraces(pattern string) []string {
+	i := strings.IndexByte(pattern, '{')
+	if i == -1 {
+		return []string{pattern}
+	}
+	j := strings.IndexByte(pattern[i:], '}')
+	if j == -1 {
+		return []string{pattern}
+	}
+	j += i
+	var patterns []string
+	for _, alt := range strings.Split(pattern[i+1:j], ",") {
+		patterns = append(patterns, expandBraces(pattern[:i]+alt+pattern[j+1:])...)
+	}
+	return patterns
+}
+
+type protobufMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+func writeProtobuf(w http.ResponseWriter, m protobufMarshaler) {
+	data, err := m.Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/protobuf")
+	w.Write(data)
+}
+
+func newFakeClient(t *testing.T, h http.Handler) (*Client, *httptest.Server) {
+	ts := httptest.NewServer(h)
+	c, err := NewClient(ts.URL, &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts
+}
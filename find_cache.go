@@ -0,0 +1,157 @@
+package carbonx
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// FindCache is an in-memory LRU cache of /metrics/find/ responses keyed by
+// pattern. It is safe for concurrent use and can be shared by Clients
+// which talk to the same server. Since the key does not include the server,
+// NewClient fails if the cache is already used by a Client for another
+// server.
+type FindCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	serverMu sync.Mutex
+	server   string
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type findCacheEntry struct {
+	pattern string
+	resp    *carbonzipperpb3.GlobResponse
+	expires time.Time
+}
+
+// NewFindCache creates a cache whose entries expire after ttl. When the
+// cache holds maxEntries entries, adding another one evicts the least
+// recently used entry. Zero or a negative maxEntries means no limit.
+func NewFindCache(ttl time.Duration, maxEntries int) *FindCache {
+	return &FindCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// WithFindCache returns a ClientOption which makes FindMetrics, and
+// therefore the tree walks, use cache. The responses returned from the
+// cache are shared and must not be modified. cache must not be used by
+// Clients for other servers.
+func WithFindCache(cache *FindCache) ClientOption {
+	return func(c *Client) {
+		c.findCache = cache
+	}
+}
+
+// bind binds the cache to the server of a Client. It returns an error if
+// the cache is already bound to another server.
+func (c *FindCache) bind(server string) error {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+
+	if c.server == "" {
+		c.server = server
+		return nil
+	}
+	if c.server != server {
+		return fmt.Errorf("FindCache is already used for server %s, cannot use it for %s", c.server, server)
+	}
+	return nil
+}
+
+func (c *FindCache) Get(pattern string) (*carbonzipperpb3.GlobResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[pattern]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*findCacheEntry)
+	if !c.now().Before(e.expires) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return e.resp, true
+}
+
+func (c *FindCache) Put(pattern string, resp *carbonzipperpb3.GlobResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[pattern]; ok {
+		e := elem.Value.(*findCacheEntry)
+		e.resp = resp
+		e.expires = expires
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.entries[pattern] = c.ll.PushFront(&findCacheEntry{
+		pattern: pattern,
+		resp:    resp,
+		expires: expires,
+	})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Invalidate removes the entry for pattern.
+func (c *FindCache) Invalidate(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[pattern]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// InvalidatePrefix removes the entries whose patterns start with prefix,
+// for example all the entries under a subtree which has been modified.
+func (c *FindCache) InvalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for pattern, elem := range c.entries {
+		if strings.HasPrefix(pattern, prefix) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// Purge removes all the entries.
+func (c *FindCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *FindCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *FindCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.entries, elem.Value.(*findCacheEntry).pattern)
+}
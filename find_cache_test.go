@@ -0,0 +1,93 @@
+package carbonx
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestFindCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := NewFindCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		cache.Put(strconv.Itoa(i), &carbonzipperpb3.GlobResponse{Name: strconv.Itoa(i)})
+	}
+	if _, ok := cache.Get("0"); ok {
+		t.Errorf("least recently used entry must be evicted")
+	}
+	if r, ok := cache.Get("1"); !ok || r.Name != "1" {
+		t.Errorf("entry 1 not found")
+	}
+	cache.Put("3", &carbonzipperpb3.GlobResponse{Name: "3"})
+	if _, ok := cache.Get("2"); ok {
+		t.Errorf("entry 2 must be evicted since entry 1 was used after it")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("unexpected cache size, got=%d, want=%d", cache.Len(), 2)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("expired entry must not be returned")
+	}
+
+	cache.Put("a.*", &carbonzipperpb3.GlobResponse{})
+	cache.Put("a.b.*", &carbonzipperpb3.GlobResponse{})
+	cache.InvalidatePrefix("a.")
+	if cache.Len() != 0 {
+		t.Errorf("unexpected cache size after InvalidatePrefix, got=%d", cache.Len())
+	}
+}
+
+func TestClientFindCache(t *testing.T) {
+	var calls int
+	server := &fakeCarbonserver{leaves: newFakeTree()}
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	cache := NewFindCache(time.Minute, 0)
+	WithFindCache(cache)(c)
+
+	walk := func() {
+		err := c.FindMetricsRecursive("n1", func(name string, isLeaf bool, err error) error {
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	walk()
+	if want := 1 + 5; calls != want {
+		t.Errorf("unexpected request count, got=%d, want=%d", calls, want)
+	}
+	walk()
+	if want := 1 + 5; calls != want {
+		t.Errorf("cached walk must not send requests, got=%d, want=%d", calls, want)
+	}
+
+	cache.Invalidate("n1.*")
+	walk()
+	if want := 1 + 5 + 1; calls != want {
+		t.Errorf("unexpected request count after Invalidate, got=%d, want=%d", calls, want)
+	}
+}
+
+func TestFindCacheServer(t *testing.T) {
+	cache := NewFindCache(time.Minute, 0)
+	if _, err := NewClient("http://user1@a.example.com:8080/", http.DefaultClient, WithFindCache(cache)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient("http://user2@a.example.com:8080/", http.DefaultClient, WithFindCache(cache)); err != nil {
+		t.Errorf("cache must be shareable by clients for the same server, got %v", err)
+	}
+	if _, err := NewClient("http://b.example.com:8080/", http.DefaultClient, WithFindCache(cache)); err == nil {
+		t.Errorf("expected error for sharing the cache with another server")
+	}
+}
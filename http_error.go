@@ -0,0 +1,55 @@
+package carbonx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const maxHTTPErrorBodyLen = 512
+
+// HTTPError is returned when a carbonserver endpoint responds with a status
+// other than 200 OK. A 404 response unwraps to ErrNotFound, so
+// errors.Is(err, ErrNotFound) keeps working.
+type HTTPError struct {
+	Endpoint string
+
+	// URL is the request URL with the password redacted.
+	URL        string
+	StatusCode int
+
+	// Body is the response body truncated to at most 512 bytes.
+	Body string
+}
+
+func newHTTPError(endpoint string, u *url.URL, statusCode int, body []byte) *HTTPError {
+	if len(body) > maxHTTPErrorBodyLen {
+		body = body[:maxHTTPErrorBodyLen]
+	}
+	return &HTTPError{
+		Endpoint:   endpoint,
+		URL:        u.Redacted(),
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s, url=%s, body=%q",
+		e.StatusCode, e.Endpoint, e.URL, e.Body)
+}
+
+func (e *HTTPError) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (e *HTTPError) IsClientError() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+func (e *HTTPError) IsServerError() bool {
+	return e.StatusCode >= 500 && e.StatusCode < 600
+}
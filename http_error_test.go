@@ -0,0 +1,47 @@
+package carbonx
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	longBody := strings.Repeat("x", 2*maxHTTPErrorBodyLen)
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case infoEndpoint:
+			http.Error(w, longBody, http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	_, err := c.GetMetricInfo("a.b")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected *HTTPError, got %v", err)
+	}
+	if httpErr.Endpoint != infoEndpoint || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected error fields, Endpoint=%s, StatusCode=%d", httpErr.Endpoint, httpErr.StatusCode)
+	}
+	if !httpErr.IsServerError() || httpErr.IsClientError() {
+		t.Errorf("unexpected status classification for %d", httpErr.StatusCode)
+	}
+	if len(httpErr.Body) != maxHTTPErrorBodyLen {
+		t.Errorf("unexpected body length, got=%d, want=%d", len(httpErr.Body), maxHTTPErrorBodyLen)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("503 must not match ErrNotFound")
+	}
+
+	_, err = c.FindMetrics("a.*")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if !errors.As(err, &httpErr) || httpErr.Endpoint != findEndpoint || !httpErr.IsClientError() {
+		t.Errorf("expected *HTTPError from %s, got %v", findEndpoint, err)
+	}
+}
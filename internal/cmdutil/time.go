@@ -0,0 +1,18 @@
+// Package cmdutil provides helpers shared by the carbonx commands.
+package cmdutil
+
+import (
+	"strconv"
+	"time"
+)
+
+// ParseTime parses s as unix time, RFC 3339 or a duration before now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
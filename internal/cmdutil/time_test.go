@@ -0,0 +1,31 @@
+package cmdutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Unix(100000, 0)
+	testCases := []struct {
+		s    string
+		want time.Time
+	}{
+		{"1500", time.Unix(1500, 0)},
+		{"1h", time.Unix(100000-3600, 0)},
+		{"2017-01-02T03:04:05Z", time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		got, err := ParseTime(tc.s, now)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tc.s, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("unexpected time for %q, got=%s, want=%s", tc.s, got, tc.want)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Errorf("expected error for invalid time")
+	}
+}
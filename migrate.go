@@ -0,0 +1,307 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// MetricsSender sends metrics to a carbon receiver. *sender.TCPSender
+// implements it.
+type MetricsSender interface {
+	Send(metrics []*carbonpb.Metric) error
+}
+
+var errNoSender = errors.New("Migrator.Sender must be set unless DryRun is true")
+
+type MigrateMode int
+
+const (
+	// MigrateMerge writes only the points which are absent in the
+	// destination.
+	MigrateMerge MigrateMode = iota
+
+	// MigrateOverwrite writes the points which are absent in the
+	// destination or whose values differ from the source.
+	MigrateOverwrite
+)
+
+func (m MigrateMode) String() string {
+	switch m {
+	case MigrateMerge:
+		return "merge"
+	case MigrateOverwrite:
+		return "overwrite"
+	default:
+		return "unknown"
+	}
+}
+
+// Migrator copies the data of metrics in the range [From, Until] from the
+// Src carbonserver to the carbon receiver behind Sender, comparing it with
+// the data in the Dest carbonserver according to Mode. When the source and
+// the destination series have different time ranges or steps, the source is
+// resampled onto the destination's time slots using the aggregation method
+// of the destination metric.
+type Migrator struct {
+	Src  *Client
+	Dest *Client
+
+	// Sender sends the points to the receiver of the destination. It must
+	// be connected before Migrate is called. Calls to Send are serialized.
+	// Sender is not used if DryRun is true, and must be set otherwise.
+	Sender MetricsSender
+
+	Mode        MigrateMode
+	From, Until time.Time
+
+	// Concurrency is the number of metrics migrated in parallel. It also
+	// limits the concurrency of the walk of the source tree. Values less
+	// than 1 mean 1.
+	Concurrency int
+
+	// Rename rewrites the source metric names to the destination names with
+	// the first matching rule. Metrics which match no rule keep their names.
+	Rename []*RenameRule
+
+	// DryRun makes Migrate compute the points to write without sending them.
+	DryRun bool
+
+	// Progress, if set, is called after each metric is processed. Calls are
+	// serialized.
+	Progress func(p MigrateProgress)
+
+	sendMu sync.Mutex
+}
+
+type MigrateProgress struct {
+	Name   string
+	Points int
+	Err    error
+
+	Done  int
+	Total int
+}
+
+type MetricError struct {
+	Name string
+	Err  error
+}
+
+func (e *MetricError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *MetricError) Unwrap() error {
+	return e.Err
+}
+
+type MigrateReport struct {
+	Metrics        int
+	MetricsWritten int
+	Points         int
+	Errors         []*MetricError
+}
+
+// Migrate migrates all the metrics under each of roots, or the root itself
+// if it is a metric. A metric under several roots is migrated once. Errors
+// for individual metrics are collected in the report and do not stop the
+// migration. The returned error is non-nil only when a source tree cannot be
+// walked or a root does not exist in the source, in which case the error is
+// *MetricError for the root wrapping ErrNotFound, ctx is done, or the Rename
+// rules map several metrics under the roots to the same name, in which case
+// nothing is written and the error is *RenameCollisionError.
+func (m *Migrator) Migrate(ctx context.Context, roots ...string) (*MigrateReport, error) {
+	err := m.checkSender()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, root := range roots {
+		rootNames, err := collectMetricNames(ctx, m.Src, root, m.Concurrency)
+		if err != nil {
+			return nil, &MetricError{Name: root, Err: err}
+		}
+		names = unionNames(names, rootNames)
+	}
+	err = checkRenameCollisions(m.Rename, names)
+	if err != nil {
+		return nil, err
+	}
+	return m.migrateMetrics(ctx, names)
+}
+
+func (m *Migrator) migrateMetrics(ctx context.Context, names []string) (*MigrateReport, error) {
+	report := &MigrateReport{}
+	var mu sync.Mutex
+	err := forEachName(ctx, names, m.Concurrency, func(name string) {
+		points, err := m.MigrateMetric(ctx, name)
+
+		mu.Lock()
+		defer mu.Unlock()
+		report.Metrics++
+		if err != nil {
+			report.Errors = append(report.Errors, &MetricError{Name: name, Err: err})
+		} else if points > 0 {
+			report.MetricsWritten++
+			report.Points += points
+		}
+		if m.Progress != nil {
+			m.Progress(MigrateProgress{
+				Name:   name,
+				Points: points,
+				Err:    err,
+				Done:   report.Metrics,
+				Total:  len(names),
+			})
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// MigrateMetric migrates one metric and returns the number of points
+// written, or to be written if DryRun is true. The metric is written under
+// the name given by the Rename rules.
+func (m *Migrator) MigrateMetric(ctx context.Context, name string) (int, error) {
+	err := m.checkSender()
+	if err != nil {
+		return 0, err
+	}
+	src, err := m.Src.FetchDataContext(ctx, name, m.From, m.Until)
+	if err != nil {
+		return 0, err
+	}
+	destName := RenameMetric(m.Rename, name)
+	dest, err := m.Dest.FetchDataContext(ctx, destName, m.From, m.Until)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	if dest != nil && ensureSameStartStopStepTime(src, dest) != nil {
+		info, err := m.Dest.GetMetricInfoContext(ctx, destName)
+		if err != nil {
+			return 0, err
+		}
+		src, err = alignFetchResponse(src, dest, info.AggregationMethod)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	metric, err := m.convert(src, dest)
+	if err != nil {
+		return 0, err
+	}
+	metric.Metric = destName
+	if len(metric.Points) == 0 || m.DryRun {
+		return len(metric.Points), nil
+	}
+
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+	err = m.Sender.Send([]*carbonpb.Metric{metric})
+	if err != nil {
+		return 0, err
+	}
+	return len(metric.Points), nil
+}
+
+func (m *Migrator) checkSender() error {
+	if m.Sender == nil && !m.DryRun {
+		return errNoSender
+	}
+	return nil
+}
+
+// convert returns the points to write. dest is nil if the metric does not
+// exist in the destination.
+func (m *Migrator) convert(src, dest *carbonzipperpb3.FetchResponse) (*carbonpb.Metric, error) {
+	if dest == nil {
+		return convertFetchResponseToMetric(src), nil
+	}
+	switch m.Mode {
+	case MigrateOverwrite:
+		return convertFetchResponsesToMetricForOverwrite(src, dest)
+	default:
+		return convertFetchResponsesToMetricForMerge(src, dest)
+	}
+}
+
+// collectMetricNames returns the names of the leaves under root, or root
+// itself if it is a leaf. It returns ErrNotFound if root does not exist.
+func collectMetricNames(ctx context.Context, c *Client, root string, concurrency int) ([]string, error) {
+	var names []string
+	var rootNotFound bool
+	opts := &WalkOptions{
+		Concurrency: concurrency,
+		Output:      WalkLeavesOnly,
+	}
+	err := c.WalkMetrics(ctx, root, opts, func(name string, isLeaf bool, err error) error {
+		if err != nil {
+			if name == root && root != "" && errors.Is(err, ErrNotFound) {
+				rootNotFound = true
+				return nil
+			}
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !rootNotFound {
+		return names, nil
+	}
+
+	resp, err := c.FindMetricsContext(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range resp.Matches {
+		if m.Path == root && m.IsLeaf {
+			return []string{root}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// forEachName calls fn for each name with up to concurrency goroutines.
+// It returns ctx.Err() if ctx is done before all the names are processed.
+func forEachName(ctx context.Context, names []string, concurrency int, fn func(name string)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range ch {
+				fn(name)
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for _, name := range names {
+		select {
+		case ch <- name:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+	return err
+}
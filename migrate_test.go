@@ -0,0 +1,321 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+type fakeSender struct {
+	mu      sync.Mutex
+	metrics []*carbonpb.Metric
+}
+
+func (s *fakeSender) Send(metrics []*carbonpb.Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = append(s.metrics, metrics...)
+	return nil
+}
+
+func (s *fakeSender) sorted() []*carbonpb.Metric {
+	sort.Slice(s.metrics, func(i, j int) bool {
+		return s.metrics[i].Metric < s.metrics[j].Metric
+	})
+	return s.metrics
+}
+
+func newMigrateTestServers(t *testing.T) (src, dest *Client, closeFn func()) {
+	src, ts1 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c", "a.d"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 2, 3}, []bool{false, false, false}),
+			fakeSeries("a.c", []float64{4, 5, 0}, []bool{false, false, true}),
+			fakeSeries("a.d", []float64{7, 8, 9}, []bool{false, false, false}),
+		},
+	})
+	dest, ts2 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 0, 30}, []bool{false, true, false}),
+			fakeSeries("a.c", []float64{4, 5, 6}, []bool{false, false, false}),
+		},
+	})
+	return src, dest, func() {
+		ts1.Close()
+		ts2.Close()
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	src, dest, closeFn := newMigrateTestServers(t)
+	defer closeFn()
+
+	testCases := []struct {
+		mode   MigrateMode
+		points int
+		want   []*carbonpb.Metric
+	}{
+		{
+			mode:   MigrateMerge,
+			points: 4,
+			want: []*carbonpb.Metric{
+				{Metric: "a.b", Points: []carbonpb.Point{{Timestamp: 120, Value: 2}}},
+				{Metric: "a.d", Points: []carbonpb.Point{{Timestamp: 60, Value: 7}, {Timestamp: 120, Value: 8}, {Timestamp: 180, Value: 9}}},
+			},
+		},
+		{
+			mode:   MigrateOverwrite,
+			points: 5,
+			want: []*carbonpb.Metric{
+				{Metric: "a.b", Points: []carbonpb.Point{{Timestamp: 120, Value: 2}, {Timestamp: 180, Value: 3}}},
+				{Metric: "a.d", Points: []carbonpb.Point{{Timestamp: 60, Value: 7}, {Timestamp: 120, Value: 8}, {Timestamp: 180, Value: 9}}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		s := &fakeSender{}
+		var progress []string
+		m := &Migrator{
+			Src:         src,
+			Dest:        dest,
+			Sender:      s,
+			Mode:        tc.mode,
+			From:        time.Unix(60, 0),
+			Until:       time.Unix(240, 0),
+			Concurrency: 2,
+			Progress: func(p MigrateProgress) {
+				progress = append(progress, p.Name)
+				if p.Total != 3 {
+					t.Errorf("%s: unexpected total, got=%d, want=%d", tc.mode, p.Total, 3)
+				}
+			},
+		}
+		report, err := m.Migrate(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Metrics != 3 || report.MetricsWritten != 2 || report.Points != tc.points || len(report.Errors) != 0 {
+			t.Errorf("%s: unexpected report, got=%+v", tc.mode, report)
+		}
+		if len(progress) != 3 {
+			t.Errorf("%s: unexpected progress calls, got=%v", tc.mode, progress)
+		}
+		if got := s.sorted(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: unexpected metrics sent,\ngot =%v,\nwant=%v", tc.mode, got, tc.want)
+		}
+
+		s = &fakeSender{}
+		m.Sender = s
+		m.DryRun = true
+		m.Progress = nil
+		report, err = m.Migrate(context.Background(), "a.d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Metrics != 1 || report.Points != 3 || len(s.metrics) != 0 {
+			t.Errorf("%s: unexpected dry run result, report=%+v, sent=%v", tc.mode, report, s.metrics)
+		}
+	}
+}
+
+func TestMigratorMissingRoot(t *testing.T) {
+	src, dest, closeFn := newMigrateTestServers(t)
+	defer closeFn()
+
+	s := &fakeSender{}
+	m := &Migrator{
+		Src:    src,
+		Dest:   dest,
+		Sender: s,
+		From:   time.Unix(60, 0),
+		Until:  time.Unix(240, 0),
+	}
+	for _, root := range []string{"x", "a.x", "a.b.x"} {
+		report, err := m.Migrate(context.Background(), root)
+		if !errors.Is(err, ErrNotFound) || report != nil {
+			t.Errorf("expected ErrNotFound for %s, got report=%+v, err=%v", root, report, err)
+		}
+	}
+	if len(s.metrics) != 0 {
+		t.Errorf("nothing must be written for a missing root, got=%v", s.metrics)
+	}
+}
+
+func TestMigratorNoSender(t *testing.T) {
+	src, dest, closeFn := newMigrateTestServers(t)
+	defer closeFn()
+
+	m := &Migrator{
+		Src:   src,
+		Dest:  dest,
+		From:  time.Unix(60, 0),
+		Until: time.Unix(240, 0),
+	}
+	if _, err := m.Migrate(context.Background(), "a"); err != errNoSender {
+		t.Errorf("unexpected Migrate error, got=%v, want=%v", err, errNoSender)
+	}
+	if _, err := m.MigrateMetric(context.Background(), "a.d"); err != errNoSender {
+		t.Errorf("unexpected MigrateMetric error, got=%v, want=%v", err, errNoSender)
+	}
+
+	m.DryRun = true
+	points, err := m.MigrateMetric(context.Background(), "a.d")
+	if err != nil || points != 3 {
+		t.Errorf("unexpected dry run result, points=%d, err=%v", points, err)
+	}
+}
+
+func TestRenameRule(t *testing.T) {
+	glob, err := NewGlobRenameRule("app.host?.{cpu,mem}.*", "service.app.host$1.${3}_$2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := NewRegexpRenameRule(`app\.(.*)`, "legacy.$1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []*RenameRule{glob, re}
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{"app.host1.cpu.user", "service.app.host1.user_cpu"},
+		{"app.host1.disk.sda", "legacy.host1.disk.sda"},
+		{"app.host10.mem.free", "legacy.host10.mem.free"},
+		{"other.app.x", "other.app.x"},
+	}
+	for _, tc := range testCases {
+		if got := RenameMetric(rules, tc.name); got != tc.want {
+			t.Errorf("unexpected rename of %s, got=%s, want=%s", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := NewGlobRenameRule("app.[ab", "x"); err == nil {
+		t.Errorf("expected error for unclosed '['")
+	}
+	if _, err := NewGlobRenameRule("app.{a,{b,c}}", "x"); err == nil {
+		t.Errorf("expected error for nested '{'")
+	}
+}
+
+func TestGlobRenameRuleWildcards(t *testing.T) {
+	testCases := []struct {
+		glob     string
+		template string
+		name     string
+		want     string
+		wantOK   bool
+	}{
+		{"app.host[!0-4].*", "$1:$2", "app.host7.cpu", "7:cpu", true},
+		{"app.host[!0-4].*", "$1:$2", "app.host3.cpu", "", false},
+		{"app.{web*,db}.cpu", "$1", "app.web12.cpu", "web12", true},
+		{"app.{web*,db}.cpu", "$1", "app.db.cpu", "db", true},
+		{"app.{web*,db}.cpu", "$1", "app.dbx.cpu", "", false},
+		{"app.{h?,[!x]z}.*", "$1-$2", "app.yz.mem", "yz-mem", true},
+	}
+	for _, tc := range testCases {
+		rule, err := NewGlobRenameRule(tc.glob, tc.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := rule.Rename(tc.name)
+		if ok != tc.wantOK || (ok && got != tc.want) {
+			t.Errorf("unexpected rename of %s with %s, got=%s,%v, want=%s,%v", tc.name, tc.glob, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestMigratorRename(t *testing.T) {
+	src, dest, closeFn := newMigrateTestServers(t)
+	defer closeFn()
+
+	rule, err := NewRegexpRenameRule(`a\.(b|d)`, "x.$1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSender{}
+	m := &Migrator{
+		Src:    src,
+		Dest:   dest,
+		Sender: s,
+		Mode:   MigrateMerge,
+		From:   time.Unix(60, 0),
+		Until:  time.Unix(240, 0),
+		Rename: []*RenameRule{rule},
+	}
+	_, err = m.Migrate(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*carbonpb.Metric{
+		{Metric: "x.b", Points: []carbonpb.Point{{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 2}, {Timestamp: 180, Value: 3}}},
+		{Metric: "x.d", Points: []carbonpb.Point{{Timestamp: 60, Value: 7}, {Timestamp: 120, Value: 8}, {Timestamp: 180, Value: 9}}},
+	}
+	if got := s.sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics sent,\ngot =%v,\nwant=%v", got, want)
+	}
+
+	rule, err = NewGlobRenameRule("a.{b,c}", "a.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = &fakeSender{}
+	m.Sender = s
+	m.Rename = []*RenameRule{rule}
+	_, err = m.Migrate(context.Background(), "a")
+	var collisionErr *RenameCollisionError
+	if !errors.As(err, &collisionErr) {
+		t.Fatalf("expected *RenameCollisionError, got %v", err)
+	}
+	if got, want := collisionErr.Collisions, map[string][]string{"a.c": {"a.b", "a.c"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected collisions, got=%v, want=%v", got, want)
+	}
+	if len(s.metrics) != 0 {
+		t.Errorf("nothing must be written on collisions, got=%v", s.metrics)
+	}
+
+	// The collisions are checked across all the roots.
+	_, err = m.Migrate(context.Background(), "a.b", "a.c")
+	if !errors.As(err, &collisionErr) {
+		t.Fatalf("expected *RenameCollisionError for several roots, got %v", err)
+	}
+	if len(s.metrics) != 0 {
+		t.Errorf("nothing must be written on collisions, got=%v", s.metrics)
+	}
+}
+
+func TestMigratorRoots(t *testing.T) {
+	src, dest, closeFn := newMigrateTestServers(t)
+	defer closeFn()
+
+	s := &fakeSender{}
+	m := &Migrator{
+		Src:    src,
+		Dest:   dest,
+		Sender: s,
+		Mode:   MigrateMerge,
+		From:   time.Unix(60, 0),
+		Until:  time.Unix(240, 0),
+	}
+	report, err := m.Migrate(context.Background(), "a.d", "a", "a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Metrics != 3 {
+		t.Errorf("unexpected metrics count, got=%d, want=%d", report.Metrics, 3)
+	}
+
+	_, err = m.Migrate(context.Background(), "a.b", "x")
+	var rootErr *MetricError
+	if !errors.As(err, &rootErr) || rootErr.Name != "x" || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for root x, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// MultiClient sends each request to several carbonservers in parallel and
+// merges the responses like carbonzipper does.
+//
+// When some backends fail and others succeed, the methods return the merged
+// responses of the successful backends together with a *BackendErrors.
+// A backend which responds with ErrNotFound is not counted as a failure.
+// When no backend has the requested data, the methods return ErrNotFound.
+type MultiClient struct {
+	clients []*Client
+}
+
+func NewMultiClient(clients ...*Client) *MultiClient {
+	return &MultiClient{clients: clients}
+}
+
+func (c *MultiClient) Clients() []*Client {
+	return c.clients
+}
+
+// BackendError is an error from one backend of a MultiClient.
+type BackendError struct {
+	Backend string
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("backend %s: %s", e.Backend, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// BackendErrors is returned by MultiClient methods when some backends fail.
+type BackendErrors struct {
+	Errors []*BackendError
+}
+
+func (e *BackendErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d backend(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// forEach calls fn for each client in parallel and collects the errors
+// other than ErrNotFound. It returns the number of successful calls.
+func (c *MultiClient) forEach(fn func(i int, client *Client) error) (int, *BackendErrors) {
+	errs := make([]error, len(c.clients))
+	var wg sync.WaitGroup
+	for i, client := range c.clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			errs[i] = fn(i, client)
+		}(i, client)
+	}
+	wg.Wait()
+
+	var succeeded int
+	var backendErrs []*BackendError
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrNotFound) {
+			backendErrs = append(backendErrs, &BackendError{
+				Backend: c.clients[i].ServerURL(),
+				Err:     err,
+			})
+		}
+	}
+	if len(backendErrs) == 0 {
+		return succeeded, nil
+	}
+	return succeeded, &BackendErrors{Errors: backendErrs}
+}
+
+// multiResult returns the error to return with a merged response.
+func multiResult(succeeded int, errs *BackendErrors) error {
+	if errs != nil {
+		return errs
+	}
+	if succeeded == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c *MultiClient) FindMetrics(pattern string) (*carbonzipperpb3.GlobResponse, error) {
+	return c.FindMetricsContext(context.Background(), pattern)
+}
+
+// FindMetricsContext returns the union of the matches from all the backends
+// sorted by path.
+func (c *MultiClient) FindMetricsContext(ctx context.Context, pattern string) (*carbonzipperpb3.GlobResponse, error) {
+	responses := make([]*carbonzipperpb3.GlobResponse, len(c.clients))
+	succeeded, errs := c.forEach(func(i int, client *Client) error {
+		var err error
+		responses[i], err = client.FindMetricsContext(ctx, pattern)
+		return err
+	})
+	if succeeded == 0 {
+		return nil, multiResult(succeeded, errs)
+	}
+
+	type matchKey struct {
+		path   string
+		isLeaf bool
+	}
+	seen := make(map[matchKey]bool)
+	merged := &carbonzipperpb3.GlobResponse{Name: pattern}
+	for _, r := range responses {
+		if r == nil {
+			continue
+		}
+		for _, m := range r.Matches {
+			key := matchKey{path: m.Path, isLeaf: m.IsLeaf}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged.Matches = append(merged.Matches, m)
+		}
+	}
+	sort.SliceStable(merged.Matches, func(i, j int) bool {
+		return merged.Matches[i].Path < merged.Matches[j].Path
+	})
+	return merged, multiResult(succeeded, errs)
+}
+
+func (c *MultiClient) GetMetricInfo(name string) (*carbonzipperpb3.ZipperInfoResponse, error) {
+	return c.GetMetricInfoContext(context.Background(), name)
+}
+
+// GetMetricInfoContext returns the info from each backend which has the
+// metric, with the server URL of the backend as the server name.
+func (c *MultiClient) GetMetricInfoContext(ctx context.Context, name string) (*carbonzipperpb3.ZipperInfoResponse, error) {
+	infos := make([]*carbonzipperpb3.InfoResponse, len(c.clients))
+	succeeded, errs := c.forEach(func(i int, client *Client) error {
+		var err error
+		infos[i], err = client.GetMetricInfoContext(ctx, name)
+		return err
+	})
+	if succeeded == 0 {
+		return nil, multiResult(succeeded, errs)
+	}
+
+	merged := &carbonzipperpb3.ZipperInfoResponse{}
+	for i, info := range infos {
+		if info == nil {
+			continue
+		}
+		merged.Responses = append(merged.Responses, carbonzipperpb3.ServerInfoResponse{
+			Server: c.clients[i].ServerURL(),
+			Info:   info,
+		})
+	}
+	return merged, multiResult(succeeded, errs)
+}
+
+func (c *MultiClient) Render(targets []string, from, until time.Time) ([]carbonzipperpb3.FetchResponse, error) {
+	return c.RenderContext(context.Background(), targets, from, until)
+}
+
+// RenderContext renders targets on all the backends and merges the series
+// with the same name by filling absent points from the other backends.
+// The series are returned in the order of their first appearance.
+func (c *MultiClient) RenderContext(ctx context.Context, targets []string, from, until time.Time) ([]carbonzipperpb3.FetchResponse, error) {
+	responses := make([][]carbonzipperpb3.FetchResponse, len(c.clients))
+	succeeded, errs := c.forEach(func(i int, client *Client) error {
+		var err error
+		responses[i], err = client.RenderContext(ctx, targets, from, until)
+		return err
+	})
+	if succeeded == 0 {
+		return nil, multiResult(succeeded, errs)
+	}
+
+	var names []string
+	byName := make(map[string][]*carbonzipperpb3.FetchResponse)
+	for _, rs := range responses {
+		for i := range rs {
+			r := &rs[i]
+			if _, ok := byName[r.Name]; !ok {
+				names = append(names, r.Name)
+			}
+			byName[r.Name] = append(byName[r.Name], r)
+		}
+	}
+	if len(names) == 0 && errs == nil {
+		return nil, ErrNotFound
+	}
+	merged := make([]carbonzipperpb3.FetchResponse, len(names))
+	for i, name := range names {
+		merged[i] = *mergeFetchResponses(byName[name])
+	}
+	return merged, multiResult(succeeded, errs)
+}
+
+// FetchData fetches one series from all the backends and merges them.
+func (c *MultiClient) FetchData(name string, from, until time.Time) (*carbonzipperpb3.FetchResponse, error) {
+	return c.FetchDataContext(context.Background(), name, from, until)
+}
+
+func (c *MultiClient) FetchDataContext(ctx context.Context, name string, from, until time.Time) (*carbonzipperpb3.FetchResponse, error) {
+	responses := make([]*carbonzipperpb3.FetchResponse, len(c.clients))
+	succeeded, errs := c.forEach(func(i int, client *Client) error {
+		var err error
+		responses[i], err = client.FetchDataContext(ctx, name, from, until)
+		return err
+	})
+	if succeeded == 0 {
+		return nil, multiResult(succeeded, errs)
+	}
+
+	var found []*carbonzipperpb3.FetchResponse
+	for _, r := range responses {
+		if r != nil {
+			found = append(found, r)
+		}
+	}
+	return mergeFetchResponses(found), multiResult(succeeded, errs)
+}
@@ -0,0 +1,105 @@
+package carbonx
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestMergeFetchResponses(t *testing.T) {
+	a := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 300, StepTime: 60,
+		Values: []float64{1, 0, 0, 4}, IsAbsent: []bool{false, true, true, false}}
+	b := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 300, StepTime: 60,
+		Values: []float64{9, 2, 0, 9}, IsAbsent: []bool{false, false, true, false}}
+	c := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 300, StepTime: 60,
+		Values: []float64{0, 0, 3, 0}, IsAbsent: []bool{true, true, false, true}}
+	coarse := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 0, StopTime: 300, StepTime: 300,
+		Values: []float64{5}, IsAbsent: []bool{false}}
+
+	got := mergeFetchResponses([]*carbonzipperpb3.FetchResponse{coarse, a, b, c})
+	want := &carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 300, StepTime: 60,
+		Values: []float64{9, 2, 3, 9}, IsAbsent: []bool{false, false, false, false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected merge result,\ngot =%+v,\nwant=%+v", got, want)
+	}
+	if b.Values[2] != 0 || !b.IsAbsent[2] {
+		t.Errorf("merge must not modify the inputs")
+	}
+}
+
+func TestMultiClient(t *testing.T) {
+	c1, ts1 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 0}, []bool{false, true}),
+			fakeSeries("a.c", []float64{3, 4}, []bool{false, false}),
+		},
+	})
+	defer ts1.Close()
+	c2, ts2 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.d"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{0, 2}, []bool{true, false}),
+			fakeSeries("a.d", []float64{5, 6}, []bool{false, false}),
+		},
+	})
+	defer ts2.Close()
+	c3, ts3 := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer ts3.Close()
+
+	mc := NewMultiClient(c1, c2)
+	glob, err := mc.FindMetrics("a.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, m := range glob.Matches {
+		paths = append(paths, m.Path)
+	}
+	if want := []string{"a.b", "a.c", "a.d"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("unexpected matches, got=%v, want=%v", paths, want)
+	}
+
+	from, until := time.Unix(60, 0), time.Unix(180, 0)
+	r, err := mc.FetchData("a.b", from, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, 2}; !reflect.DeepEqual(r.Values, want) || countAbsent(r) != 0 {
+		t.Errorf("unexpected merged values, got=%v, isAbsent=%v", r.Values, r.IsAbsent)
+	}
+
+	rs, err := mc.Render([]string{"a.*"}, from, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 3 {
+		t.Errorf("unexpected series count, got=%d, want=%d", len(rs), 3)
+	}
+
+	mc = NewMultiClient(c1, c2, c3)
+	rs, err = mc.Render([]string{"a.d"}, from, until)
+	var backendErrs *BackendErrors
+	if !errors.As(err, &backendErrs) || len(backendErrs.Errors) != 1 ||
+		backendErrs.Errors[0].Backend != c3.ServerURL() {
+		t.Fatalf("expected an error for the third backend, got %v", err)
+	}
+	var httpErr *HTTPError
+	if !errors.As(backendErrs.Errors[0], &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected *HTTPError, got %v", backendErrs.Errors[0].Err)
+	}
+	if len(rs) != 1 || rs[0].Name != "a.d" {
+		t.Errorf("unexpected partial result, got=%+v", rs)
+	}
+
+	_, err = NewMultiClient(c1, c2).FetchData("no.such", from, until)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
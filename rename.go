@@ -0,0 +1,160 @@
+package carbonx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RenameRule rewrites metric names which match a regular expression using
+// a template in the syntax of regexp.Regexp.Expand, for example
+// service.$1.$2.
+type RenameRule struct {
+	re       *regexp.Regexp
+	template string
+}
+
+// NewRegexpRenameRule creates a rule from a regular expression. The
+// expression must match the whole metric name.
+func NewRegexpRenameRule(pattern, template string) (*RenameRule, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &RenameRule{re: re, template: template}, nil
+}
+
+// NewGlobRenameRule creates a rule from a Graphite glob like app.*.cpu.
+// Each '*', '?', character class [...] or [!...] and alternation {a,b} in
+// the glob is a capture group, numbered from $1 in order. The alternatives
+// may contain wildcards, which are matched but not captured separately.
+// For example, the rule with the glob app.* and the template
+// service.app.$1 renames app.host1 to service.app.host1.
+func NewGlobRenameRule(glob, template string) (*RenameRule, error) {
+	pattern, err := globToRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegexpRenameRule(pattern, template)
+}
+
+func globToRegexp(glob string) (string, error) {
+	return translateGlob(glob, true)
+}
+
+// translateGlob translates glob to a regular expression. If capture is
+// true, each wildcard is a capture group. The alternatives in {a,b} are
+// translated without capture groups so that the numbering of the groups
+// follows the wildcards at the top level.
+func translateGlob(glob string, capture bool) (string, error) {
+	var b strings.Builder
+	writeGroup := func(re string) {
+		if capture {
+			b.WriteString("(" + re + ")")
+		} else {
+			b.WriteString("(?:" + re + ")")
+		}
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			writeGroup(`[^.]*`)
+		case '?':
+			writeGroup(`[^.]`)
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j == -1 {
+				return "", fmt.Errorf("unclosed '[' in glob %q", glob)
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			writeGroup("[" + class + "]")
+			i += j
+		case '{':
+			j := strings.IndexByte(glob[i:], '}')
+			if j == -1 {
+				return "", fmt.Errorf("unclosed '{' in glob %q", glob)
+			}
+			if strings.IndexByte(glob[i+1:i+j], '{') != -1 {
+				return "", fmt.Errorf("nested '{' in glob %q", glob)
+			}
+			alts := strings.Split(glob[i+1:i+j], ",")
+			for k, alt := range alts {
+				re, err := translateGlob(alt, false)
+				if err != nil {
+					return "", err
+				}
+				alts[k] = re
+			}
+			writeGroup(strings.Join(alts, "|"))
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// Rename returns the rewritten name and true if name matches r.
+func (r *RenameRule) Rename(name string) (string, bool) {
+	match := r.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name, false
+	}
+	return string(r.re.ExpandString(nil, r.template, name, match)), true
+}
+
+// RenameMetric rewrites name with the first rule which matches it. It
+// returns name unchanged if no rule matches.
+func RenameMetric(rules []*RenameRule, name string) string {
+	for _, r := range rules {
+		if newName, ok := r.Rename(name); ok {
+			return newName
+		}
+	}
+	return name
+}
+
+// RenameCollisionError is returned when several source metrics are renamed
+// to the same destination metric.
+type RenameCollisionError struct {
+	// Collisions maps each destination name to the sorted source names.
+	Collisions map[string][]string
+}
+
+func (e *RenameCollisionError) Error() string {
+	dests := make([]string, 0, len(e.Collisions))
+	for dest := range e.Collisions {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+	msgs := make([]string, len(dests))
+	for i, dest := range dests {
+		msgs[i] = fmt.Sprintf("%s <- %s", dest, strings.Join(e.Collisions[dest], ", "))
+	}
+	return "rename collisions: " + strings.Join(msgs, "; ")
+}
+
+// checkRenameCollisions returns *RenameCollisionError if several names are
+// renamed to the same name by rules.
+func checkRenameCollisions(rules []*RenameRule, names []string) error {
+	sources := make(map[string][]string)
+	for _, name := range names {
+		dest := RenameMetric(rules, name)
+		sources[dest] = append(sources[dest], name)
+	}
+	collisions := make(map[string][]string)
+	for dest, srcs := range sources {
+		if len(srcs) > 1 {
+			sort.Strings(srcs)
+			collisions[dest] = srcs
+		}
+	}
+	if len(collisions) > 0 {
+		return &RenameCollisionError{Collisions: collisions}
+	}
+	return nil
+}
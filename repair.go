@@ -0,0 +1,242 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// Replica is a go-carbon node which receives the same writes as the other
+// replicas.
+type Replica struct {
+	// Client reads from the carbonserver of the node.
+	Client *Client
+
+	// Sender writes to the receiver of the node. It must be connected
+	// before Repair is called. Calls to Send are serialized per replica.
+	Sender MetricsSender
+
+	sendMu sync.Mutex
+}
+
+// Repairer fills the gaps of metrics in replicas. For each metric, it reads
+// the data in the range [From, Until] from all the replicas, builds the
+// union of the present points, and writes to each replica only the points
+// it is missing.
+type Repairer struct {
+	Replicas    []*Replica
+	From, Until time.Time
+
+	// PointsPerSecond limits the rate of the points written to all the
+	// replicas across the calls to Repair and RepairMetric. Zero means no
+	// limit. It must not be changed after the first call.
+	PointsPerSecond float64
+
+	// Concurrency is the number of metrics repaired in parallel. Values
+	// less than 1 mean 1.
+	Concurrency int
+
+	// DryRun makes Repair compute the points to write without sending them.
+	DryRun bool
+
+	limiterOnce sync.Once
+	limiter     *rateLimiter
+}
+
+type RepairReport struct {
+	Metrics int
+
+	// PointsRepaired[i] is the number of points written to Replicas[i].
+	PointsRepaired []int
+
+	// Failures[i] is the number of errors of Replicas[i], which are
+	// included in Errors.
+	Failures []int
+
+	Errors []*MetricError
+}
+
+// ReplicaError is the error of the replica Replicas[Index].
+type ReplicaError struct {
+	Index  int
+	Server string
+	Err    error
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d (%s): %s", e.Index, e.Server, e.Err)
+}
+
+func (e *ReplicaError) Unwrap() error {
+	return e.Err
+}
+
+// ReplicaErrors is the errors of the replicas which failed. The other
+// replicas are still repaired.
+type ReplicaErrors []*ReplicaError
+
+func (e ReplicaErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (r *Repairer) replicaError(i int, err error) *ReplicaError {
+	return &ReplicaError{Index: i, Server: r.Replicas[i].Client.ServerURL(), Err: err}
+}
+
+// Repair repairs all the metrics under root, or root itself if it is a
+// metric, which exist on any replica. Errors for individual metrics and
+// replicas are collected in the report and do not stop the repair. A
+// replica whose tree cannot be walked is still repaired for the metrics
+// found on the others. The returned error is non-nil only when no replica
+// can be walked or ctx is done.
+func (r *Repairer) Repair(ctx context.Context, root string) (*RepairReport, error) {
+	report := &RepairReport{
+		PointsRepaired: make([]int, len(r.Replicas)),
+		Failures:       make([]int, len(r.Replicas)),
+	}
+	var names []string
+	var walkErrs ReplicaErrors
+	for i, replica := range r.Replicas {
+		replicaNames, err := collectMetricNames(ctx, replica.Client, root, r.Concurrency)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			walkErrs = append(walkErrs, r.replicaError(i, err))
+			continue
+		}
+		names = unionNames(names, replicaNames)
+	}
+	if len(walkErrs) > 0 {
+		if len(walkErrs) == len(r.Replicas) {
+			return nil, walkErrs
+		}
+		report.addError(root, walkErrs)
+	}
+
+	var mu sync.Mutex
+	err := forEachName(ctx, names, r.Concurrency, func(name string) {
+		repaired, err := r.RepairMetric(ctx, name)
+
+		mu.Lock()
+		defer mu.Unlock()
+		report.Metrics++
+		for i, n := range repaired {
+			report.PointsRepaired[i] += n
+		}
+		if err != nil {
+			report.addError(name, err)
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func (r *RepairReport) addError(name string, err error) {
+	var replicaErrs ReplicaErrors
+	if errors.As(err, &replicaErrs) {
+		for _, replicaErr := range replicaErrs {
+			r.Failures[replicaErr.Index]++
+		}
+	}
+	r.Errors = append(r.Errors, &MetricError{Name: name, Err: err})
+}
+
+// RepairMetric repairs one metric and returns the number of points written
+// to each replica, or to be written if DryRun is true. A replica which
+// cannot be read or written is skipped and the others are repaired, in
+// which case the error is ReplicaErrors. It returns ErrNotFound if no
+// replica has the metric.
+func (r *Repairer) RepairMetric(ctx context.Context, name string) ([]int, error) {
+	responses := make([]*carbonzipperpb3.FetchResponse, len(r.Replicas))
+	failed := make([]bool, len(r.Replicas))
+	var found []*carbonzipperpb3.FetchResponse
+	var errs ReplicaErrors
+	for i, replica := range r.Replicas {
+		resp, err := replica.Client.FetchDataContext(ctx, name, r.From, r.Until)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failed[i] = true
+			errs = append(errs, r.replicaError(i, err))
+			continue
+		}
+		responses[i] = resp
+		found = append(found, resp)
+	}
+	if len(found) == 0 {
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		return nil, ErrNotFound
+	}
+	union := mergeFetchResponses(found)
+
+	repaired := make([]int, len(r.Replicas))
+	for i, replica := range r.Replicas {
+		if failed[i] {
+			continue
+		}
+		var metric *carbonpb.Metric
+		if responses[i] == nil {
+			metric = convertFetchResponseToMetric(union)
+		} else {
+			var err error
+			metric, err = convertFetchResponsesToMetricForMerge(union, responses[i])
+			if err != nil {
+				errs = append(errs, r.replicaError(i, err))
+				continue
+			}
+		}
+		if len(metric.Points) == 0 {
+			continue
+		}
+		if !r.DryRun {
+			err := r.rateLimiter().wait(ctx, len(metric.Points))
+			if err != nil {
+				return repaired, err
+			}
+			err = replica.send(metric)
+			if err != nil {
+				errs = append(errs, r.replicaError(i, err))
+				continue
+			}
+		}
+		repaired[i] = len(metric.Points)
+	}
+	if len(errs) > 0 {
+		return repaired, errs
+	}
+	return repaired, nil
+}
+
+// rateLimiter returns the limiter shared by all the calls to Repair and
+// RepairMetric, creating it on the first call.
+func (r *Repairer) rateLimiter() *rateLimiter {
+	r.limiterOnce.Do(func() {
+		r.limiter = newRateLimiter(r.PointsPerSecond)
+	})
+	return r.limiter
+}
+
+func (r *Replica) send(metric *carbonpb.Metric) error {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	return r.Sender.Send([]*carbonpb.Metric{metric})
+}
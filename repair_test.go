@@ -0,0 +1,181 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestRepairer(t *testing.T) {
+	c1, ts1 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 0, 3}, []bool{false, true, false}),
+			fakeSeries("a.c", []float64{4, 5, 6}, []bool{false, false, false}),
+		},
+	})
+	defer ts1.Close()
+	c2, ts2 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{0, 2, 0}, []bool{true, false, true}),
+		},
+	})
+	defer ts2.Close()
+
+	s1, s2 := &fakeSender{}, &fakeSender{}
+	r := &Repairer{
+		Replicas: []*Replica{
+			{Client: c1, Sender: s1},
+			{Client: c2, Sender: s2},
+		},
+		From:            time.Unix(60, 0),
+		Until:           time.Unix(240, 0),
+		PointsPerSecond: 1000,
+		Concurrency:     2,
+	}
+	report, err := r.Repair(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Metrics != 2 || !reflect.DeepEqual(report.PointsRepaired, []int{1, 5}) || len(report.Errors) != 0 {
+		t.Errorf("unexpected report, got=%+v", report)
+	}
+
+	want1 := []*carbonpb.Metric{
+		{Metric: "a.b", Points: []carbonpb.Point{{Timestamp: 120, Value: 2}}},
+	}
+	if got := s1.sorted(); !reflect.DeepEqual(got, want1) {
+		t.Errorf("unexpected metrics sent to replica 1,\ngot =%v,\nwant=%v", got, want1)
+	}
+	want2 := []*carbonpb.Metric{
+		{Metric: "a.b", Points: []carbonpb.Point{{Timestamp: 60, Value: 1}, {Timestamp: 180, Value: 3}}},
+		{Metric: "a.c", Points: []carbonpb.Point{{Timestamp: 60, Value: 4}, {Timestamp: 120, Value: 5}, {Timestamp: 180, Value: 6}}},
+	}
+	if got := s2.sorted(); !reflect.DeepEqual(got, want2) {
+		t.Errorf("unexpected metrics sent to replica 2,\ngot =%v,\nwant=%v", got, want2)
+	}
+}
+
+func TestRepairerReplicaFailure(t *testing.T) {
+	c1, ts1 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b", "a.c"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{1, 0, 3}, []bool{false, true, false}),
+			fakeSeries("a.c", []float64{4, 5, 6}, []bool{false, false, false}),
+		},
+	})
+	defer ts1.Close()
+	c2, ts2 := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.b"},
+		series: []carbonzipperpb3.FetchResponse{
+			fakeSeries("a.b", []float64{0, 2, 0}, []bool{true, false, true}),
+		},
+	})
+	defer ts2.Close()
+	c3, ts3 := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer ts3.Close()
+
+	s1, s2, s3 := &fakeSender{}, &fakeSender{}, &fakeSender{}
+	r := &Repairer{
+		Replicas: []*Replica{
+			{Client: c1, Sender: s1},
+			{Client: c2, Sender: s2},
+			{Client: c3, Sender: s3},
+		},
+		From:  time.Unix(60, 0),
+		Until: time.Unix(240, 0),
+	}
+	report, err := r.Repair(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Metrics != 2 || !reflect.DeepEqual(report.PointsRepaired, []int{1, 5, 0}) {
+		t.Errorf("unexpected report, got=%+v", report)
+	}
+	if !reflect.DeepEqual(report.Failures, []int{0, 0, 3}) || len(report.Errors) != 3 {
+		t.Errorf("unexpected failures, got=%v, errors=%v", report.Failures, report.Errors)
+	}
+	for _, err := range report.Errors {
+		var replicaErrs ReplicaErrors
+		if !errors.As(err, &replicaErrs) || len(replicaErrs) != 1 ||
+			replicaErrs[0].Index != 2 || replicaErrs[0].Server != ts3.URL {
+			t.Errorf("unexpected error, got=%v", err)
+		}
+	}
+	if len(s2.metrics) != 2 || len(s3.metrics) != 0 {
+		t.Errorf("unexpected metrics sent, replica 2=%v, replica 3=%v", s2.metrics, s3.metrics)
+	}
+
+	r.Replicas = r.Replicas[2:]
+	var replicaErrs ReplicaErrors
+	if _, err := r.Repair(context.Background(), "a"); !errors.As(err, &replicaErrs) {
+		t.Errorf("expected ReplicaErrors when no replica can be walked, got %v", err)
+	}
+}
+
+func TestRepairMetricRateLimit(t *testing.T) {
+	c1, ts1 := newFakeClient(t, &fakeCarbonserver{
+		series: []carbonzipperpb3.FetchResponse{{Name: "a.b", StartTime: 60, StopTime: 120, StepTime: 60,
+			Values: []float64{1}, IsAbsent: []bool{false}}},
+	})
+	defer ts1.Close()
+	c2, ts2 := newFakeClient(t, &fakeCarbonserver{})
+	defer ts2.Close()
+
+	r := &Repairer{
+		Replicas: []*Replica{
+			{Client: c1, Sender: &fakeSender{}},
+			{Client: c2, Sender: &fakeSender{}},
+		},
+		From:            time.Unix(60, 0),
+		Until:           time.Unix(120, 0),
+		PointsPerSecond: 10,
+	}
+	// Each call writes one point to the second replica, so three calls must
+	// take at least 200ms when the limit is shared between them.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		repaired, err := r.RepairMetric(context.Background(), "a.b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(repaired, []int{0, 1}) {
+			t.Errorf("unexpected points repaired, got=%v", repaired)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("rate limit not shared between calls, elapsed=%s", elapsed)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.wait(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("rate limiter did not wait, elapsed=%s", elapsed)
+	}
+
+	l = newRateLimiter(1)
+	if err := l.wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 1); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package carbonx
+
+import "net/http"
+
+// RequestDecorator modifies an outgoing request before it is sent, for
+// example to add authentication or tenant headers.
+type RequestDecorator func(req *http.Request) error
+
+// WithRequestDecorators returns a ClientOption which applies decorators in
+// order to every request sent by the Client.
+func WithRequestDecorators(decorators ...RequestDecorator) ClientOption {
+	return func(c *Client) {
+		c.decorators = append(c.decorators, decorators...)
+	}
+}
+
+func BasicAuth(username, password string) RequestDecorator {
+	return func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	}
+}
+
+func BearerToken(token string) RequestDecorator {
+	return StaticHeader("Authorization", "Bearer "+token)
+}
+
+func StaticHeader(key, value string) RequestDecorator {
+	return func(req *http.Request) error {
+		req.Header.Set(key, value)
+		return nil
+	}
+}
+
+func StaticHeaders(header http.Header) RequestDecorator {
+	return func(req *http.Request) error {
+		for key, values := range header {
+			req.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package carbonx
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestRequestDecorators(t *testing.T) {
+	var gotHeader http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		writeProtobuf(w, &carbonzipperpb3.GlobResponse{Name: r.URL.Query().Get("query")})
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, &http.Client{Timeout: 5 * time.Second},
+		WithRequestDecorators(
+			BasicAuth("user", "secret"),
+			StaticHeader("User-Agent", "carbonx-test"),
+			StaticHeaders(http.Header{"x-tenant-id": {"t1"}}),
+			func(req *http.Request) error {
+				req.Header.Set("X-Target", req.URL.Query().Get("query"))
+				return nil
+			},
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.FindMetrics("a.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, password, _ := (&http.Request{Header: gotHeader}).BasicAuth(); user != "user" || password != "secret" {
+		t.Errorf("unexpected credentials, user=%s, password=%s", user, password)
+	}
+	for key, want := range map[string]string{
+		"User-Agent":  "carbonx-test",
+		"X-Tenant-Id": "t1",
+		"X-Target":    "a.*",
+	} {
+		if got := gotHeader.Get(key); got != want {
+			t.Errorf("unexpected header %s, got=%s, want=%s", key, got, want)
+		}
+	}
+
+	wantErr := errors.New("no token")
+	c, err = NewClient(ts.URL, &http.Client{Timeout: 5 * time.Second},
+		WithRequestDecorators(func(req *http.Request) error { return wantErr }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.FindMetrics("a.*")
+	if err != wantErr {
+		t.Errorf("unexpected error, got=%v, want=%v", err, wantErr)
+	}
+}
@@ -0,0 +1,64 @@
+package carbonx
+
+import (
+	"fmt"
+	"io"
+)
+
+// WithMaxResponseSize returns a ClientOption which limits the size of
+// response bodies to size bytes. Calls fail with *ResponseTooLargeError
+// when the limit is exceeded. Zero or a negative size means no limit.
+func WithMaxResponseSize(size int64) ClientOption {
+	return func(c *Client) {
+		c.maxResponseSize = size
+	}
+}
+
+type ResponseTooLargeError struct {
+	Endpoint string
+	Limit    int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response from %s exceeds the size limit of %d bytes", e.Endpoint, e.Limit)
+}
+
+// responseBody returns *ResponseTooLargeError when more than limit bytes
+// are read from the underlying body.
+type responseBody struct {
+	io.ReadCloser
+	endpoint  string
+	limit     int64
+	remaining int64
+}
+
+func newResponseBody(body io.ReadCloser, endpoint string, limit int64) io.ReadCloser {
+	if limit <= 0 {
+		return body
+	}
+	return &responseBody{
+		ReadCloser: body,
+		endpoint:   endpoint,
+		limit:      limit,
+		remaining:  limit,
+	}
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Read one more byte to distinguish hitting the limit exactly
+		// from exceeding it.
+		var buf [1]byte
+		n, err := b.ReadCloser.Read(buf[:])
+		if n > 0 {
+			return 0, &ResponseTooLargeError{Endpoint: b.endpoint, Limit: b.limit}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
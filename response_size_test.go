@@ -0,0 +1,85 @@
+package carbonx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	var series []carbonzipperpb3.FetchResponse
+	for i := 0; i < 100; i++ {
+		series = append(series, carbonzipperpb3.FetchResponse{
+			Name: fmt.Sprintf("a.b%d", i), StartTime: 60, StopTime: 120, StepTime: 60,
+			Values: []float64{float64(i)}, IsAbsent: []bool{false},
+		})
+	}
+	c, ts := newFakeClient(t, &fakeCarbonserver{series: series})
+	defer ts.Close()
+
+	from, until := time.Unix(60, 0), time.Unix(120, 0)
+	var count int
+	err := c.RenderStream([]string{"a.*"}, from, until, func(r *carbonzipperpb3.FetchResponse) error {
+		count++
+		return nil
+	})
+	if err != nil || count != len(series) {
+		t.Errorf("unexpected stream result, err=%v, count=%d", err, count)
+	}
+
+	c.maxResponseSize = 256
+	_, err = c.Render([]string{"a.*"}, from, until)
+	var sizeErr *ResponseTooLargeError
+	if !errors.As(err, &sizeErr) || sizeErr.Endpoint != renderEndpoint || sizeErr.Limit != 256 {
+		t.Errorf("expected *ResponseTooLargeError, got %v", err)
+	}
+
+	count = 0
+	err = c.RenderStream([]string{"a.*"}, from, until, func(r *carbonzipperpb3.FetchResponse) error {
+		count++
+		return nil
+	})
+	if !errors.As(err, &sizeErr) || count == 0 {
+		t.Errorf("expected *ResponseTooLargeError after some series, got err=%v, count=%d", err, count)
+	}
+
+	_, err = c.FetchData("a.b1", from, until)
+	if err != nil {
+		t.Errorf("small response must not exceed the limit, got %v", err)
+	}
+}
+
+func TestMaxResponseSizeFieldLength(t *testing.T) {
+	// A field 1 with a length prefix of 1 GiB followed by a few bytes.
+	body := []byte{0x0a, 0x80, 0x80, 0x80, 0x80, 0x04, 'a', 'b', 'c'}
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ts.Close()
+	c.maxResponseSize = 256
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := c.RenderStream([]string{"a.b"}, time.Unix(60, 0), time.Unix(120, 0), func(r *carbonzipperpb3.FetchResponse) error {
+		return nil
+	})
+	var sizeErr *ResponseTooLargeError
+	if !errors.As(err, &sizeErr) || sizeErr.Endpoint != renderEndpoint {
+		t.Errorf("expected *ResponseTooLargeError from %s, got %v", renderEndpoint, err)
+	}
+	err = c.ListMetricsStream(func(name string) error {
+		return nil
+	})
+	if !errors.As(err, &sizeErr) || sizeErr.Endpoint != listEndpoint {
+		t.Errorf("expected *ResponseTooLargeError from %s, got %v", listEndpoint, err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= 1<<30 {
+		t.Errorf("field buffer must not be allocated, allocated=%d", allocated)
+	}
+}
@@ -0,0 +1,101 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how a Client retries failed endpoint calls.
+// The zero value does not retry.
+type RetryPolicy struct {
+	// Attempts is the maximum number of calls including the first one.
+	Attempts int
+
+	// InitialBackoff is the wait time before the first retry. The wait time
+	// is multiplied by Multiplier (2 if zero) for each further retry and is
+	// capped at MaxBackoff if MaxBackoff is positive.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction of the wait time, in [0, 1], which is
+	// randomized. For example, 0.2 makes a 1s wait time between 0.8s and 1.2s.
+	Jitter float64
+
+	// RetryNotFound makes ErrNotFound retryable. This is useful when a
+	// freshly written metric is not visible yet.
+	RetryNotFound bool
+
+	// ShouldRetry overrides the default decision which retries network
+	// errors, 5xx responses and, if RetryNotFound is true, ErrNotFound.
+	ShouldRetry func(err error) bool
+}
+
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+func (p *RetryPolicy) shouldRetry(err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return p.RetryNotFound
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.IsServerError()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 0; i < retry; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retry calls fn until it succeeds, the error is not retryable, the
+// attempts are exhausted or ctx is done. The decision to stop on
+// cancellation is made with ctx rather than the error, because a timeout of
+// the http.Client also matches context.DeadlineExceeded. If ctx is done
+// while waiting for the next attempt, ctx.Err() is returned so that callers
+// can tell the retries were stopped.
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	p := &c.retryPolicy
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i+1 >= p.Attempts || ctx.Err() != nil || !p.shouldRetry(err) {
+			return err
+		}
+
+		t := time.NewTimer(p.backoff(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
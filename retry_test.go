@@ -0,0 +1,120 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var calls int
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case calls == 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case calls == 2:
+			http.NotFound(w, r)
+		default:
+			writeProtobuf(w, &carbonzipperpb3.InfoResponse{Name: r.URL.Query().Get("target")})
+		}
+	}))
+	defer ts.Close()
+
+	c.retryPolicy = RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond}
+	_, err := c.GetMetricInfo("a.b")
+	if !errors.Is(err, ErrNotFound) || calls != 2 {
+		t.Errorf("expected ErrNotFound after 2 calls, got err=%v, calls=%d", err, calls)
+	}
+
+	calls = 0
+	c.retryPolicy.RetryNotFound = true
+	info, err := c.GetMetricInfo("a.b")
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success after 3 calls, got err=%v, calls=%d", err, calls)
+	}
+	if info.Name != "a.b" {
+		t.Errorf("unexpected name, got=%s, want=%s", info.Name, "a.b")
+	}
+
+	calls = 0
+	c.retryPolicy.Attempts = 2
+	_, err = c.GetMetricInfo("a.b")
+	if !errors.Is(err, ErrNotFound) || calls != 2 {
+		t.Errorf("expected ErrNotFound after 2 calls, got err=%v, calls=%d", err, calls)
+	}
+}
+
+func TestRetryPolicyContextDone(t *testing.T) {
+	var calls int
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c.retryPolicy = RetryPolicy{Attempts: 3, InitialBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.GetMetricInfoContext(ctx, "a.b")
+	if err != context.DeadlineExceeded || calls != 1 {
+		t.Errorf("expected %v after 1 call, got err=%v, calls=%d", context.DeadlineExceeded, err, calls)
+	}
+}
+
+func TestRetryPolicyClientTimeout(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		writeProtobuf(w, &carbonzipperpb3.InfoResponse{Name: r.URL.Query().Get("target")})
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, &http.Client{Timeout: 50 * time.Millisecond},
+		WithRetryPolicy(RetryPolicy{Attempts: 2, InitialBackoff: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.GetMetricInfo("a.b")
+	if err != nil || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expected success after 2 calls, got err=%v, calls=%d", err, atomic.LoadInt32(&calls))
+	}
+	if info.Name != "a.b" {
+		t.Errorf("unexpected name, got=%s, want=%s", info.Name, "a.b")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for i, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		if got := p.backoff(i); got != want {
+			t.Errorf("unexpected backoff for retry %d, got=%s, want=%s", i, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(0); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff out of jitter range, got=%s", got)
+		}
+	}
+}
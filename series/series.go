@@ -0,0 +1,250 @@
+// Package series provides Graphite-like aggregation and transform functions
+// over carbonzipperpb3.FetchResponse values.
+//
+// Absent points are handled like Graphite handles None: they are ignored by
+// the aggregations, and a result point is absent when there is no present
+// input point to compute it from. The functions do not modify their inputs
+// and return new FetchResponse values.
+package series
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+var errNoSeries = errors.New("no series")
+
+// ensureAligned returns an error unless all the series have the same
+// StartTime, StopTime, StepTime and number of points.
+func ensureAligned(rs []*carbonzipperpb3.FetchResponse) error {
+	if len(rs) == 0 {
+		return errNoSeries
+	}
+	first := rs[0]
+	for _, r := range rs {
+		if len(r.Values) != len(r.IsAbsent) {
+			return fmt.Errorf("len(Values)=%d and len(IsAbsent)=%d unmatched in %s", len(r.Values), len(r.IsAbsent), r.Name)
+		}
+		if r.StartTime != first.StartTime || r.StopTime != first.StopTime ||
+			r.StepTime != first.StepTime || len(r.Values) != len(first.Values) {
+			return fmt.Errorf("series not aligned, %s: start=%d, stop=%d, step=%d, len=%d, %s: start=%d, stop=%d, step=%d, len=%d",
+				first.Name, first.StartTime, first.StopTime, first.StepTime, len(first.Values),
+				r.Name, r.StartTime, r.StopTime, r.StepTime, len(r.Values))
+		}
+	}
+	return nil
+}
+
+func newLike(r *carbonzipperpb3.FetchResponse, name string) *carbonzipperpb3.FetchResponse {
+	return &carbonzipperpb3.FetchResponse{
+		Name:      name,
+		StartTime: r.StartTime,
+		StopTime:  r.StopTime,
+		StepTime:  r.StepTime,
+		Values:    make([]float64, len(r.Values)),
+		IsAbsent:  make([]bool, len(r.Values)),
+	}
+}
+
+func funcName(fn string, rs []*carbonzipperpb3.FetchResponse, args ...string) string {
+	names := make([]string, 0, len(rs)+len(args))
+	for _, r := range rs {
+		names = append(names, r.Name)
+	}
+	names = append(names, args...)
+	return fn + "(" + strings.Join(names, ",") + ")"
+}
+
+// combine aggregates the present values of aligned series at each point
+// with fn.
+func combine(name string, rs []*carbonzipperpb3.FetchResponse, fn func(values []float64) float64) (*carbonzipperpb3.FetchResponse, error) {
+	err := ensureAligned(rs)
+	if err != nil {
+		return nil, err
+	}
+	result := newLike(rs[0], name)
+	values := make([]float64, 0, len(rs))
+	for i := range result.Values {
+		values = values[:0]
+		for _, r := range rs {
+			if !r.IsAbsent[i] {
+				values = append(values, r.Values[i])
+			}
+		}
+		if len(values) == 0 {
+			result.IsAbsent[i] = true
+			continue
+		}
+		result.Values[i] = fn(values)
+	}
+	return result, nil
+}
+
+func SumSeries(rs ...*carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	return combine(funcName("sumSeries", rs), rs, sum)
+}
+
+func AverageSeries(rs ...*carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	return combine(funcName("averageSeries", rs), rs, func(values []float64) float64 {
+		return sum(values) / float64(len(values))
+	})
+}
+
+func MaxSeries(rs ...*carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	return combine(funcName("maxSeries", rs), rs, func(values []float64) float64 {
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	})
+}
+
+func MinSeries(rs ...*carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	return combine(funcName("minSeries", rs), rs, func(values []float64) float64 {
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	})
+}
+
+// PercentileOfSeries returns the n-th percentile of the present values of
+// the series at each point. Like Graphite's percentileOfSeries without
+// interpolation, it picks the value at rank ceil(n/100 * (count+1)).
+func PercentileOfSeries(n float64, rs ...*carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	if n < 0 || n > 100 {
+		return nil, fmt.Errorf("percentile %g out of range [0, 100]", n)
+	}
+	name := funcName("percentileOfSeries", rs, fmt.Sprintf("%g", n))
+	return combine(name, rs, func(values []float64) float64 {
+		return percentile(values, n)
+	})
+}
+
+func percentile(values []float64, n float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(n / 100 * float64(len(sorted)+1)))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func sum(values []float64) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+// Derivative returns the difference of each point from the previous one.
+// A point is absent if it or the previous point is absent.
+func Derivative(r *carbonzipperpb3.FetchResponse) (*carbonzipperpb3.FetchResponse, error) {
+	err := ensureAligned([]*carbonzipperpb3.FetchResponse{r})
+	if err != nil {
+		return nil, err
+	}
+	result := newLike(r, funcName("derivative", []*carbonzipperpb3.FetchResponse{r}))
+	for i := range r.Values {
+		if i == 0 || r.IsAbsent[i] || r.IsAbsent[i-1] {
+			result.IsAbsent[i] = true
+			continue
+		}
+		result.Values[i] = r.Values[i] - r.Values[i-1]
+	}
+	return result, nil
+}
+
+// NonNegativeDerivative is like Derivative but for counters. A negative
+// difference means the counter has wrapped or been reset. If maxValue is
+// positive, the counter is assumed to wrap at maxValue, and the difference
+// is computed accordingly. Otherwise the point is absent. Values larger than
+// a positive maxValue are absent.
+func NonNegativeDerivative(r *carbonzipperpb3.FetchResponse, maxValue float64) (*carbonzipperpb3.FetchResponse, error) {
+	var args []string
+	if maxValue > 0 {
+		args = append(args, fmt.Sprintf("%g", maxValue))
+	}
+	return nonNegativeDerivative(r, maxValue, funcName("nonNegativeDerivative", []*carbonzipperpb3.FetchResponse{r}, args...), 1)
+}
+
+// PerSecond is like NonNegativeDerivative but divides the differences by
+// the step in seconds.
+func PerSecond(r *carbonzipperpb3.FetchResponse, maxValue float64) (*carbonzipperpb3.FetchResponse, error) {
+	var args []string
+	if maxValue > 0 {
+		args = append(args, fmt.Sprintf("%g", maxValue))
+	}
+	return nonNegativeDerivative(r, maxValue, funcName("perSecond", []*carbonzipperpb3.FetchResponse{r}, args...), float64(r.StepTime))
+}
+
+func nonNegativeDerivative(r *carbonzipperpb3.FetchResponse, maxValue float64, name string, divisor float64) (*carbonzipperpb3.FetchResponse, error) {
+	err := ensureAligned([]*carbonzipperpb3.FetchResponse{r})
+	if err != nil {
+		return nil, err
+	}
+	if divisor <= 0 {
+		return nil, fmt.Errorf("invalid StepTime %d in %s", r.StepTime, r.Name)
+	}
+	result := newLike(r, name)
+	prevAbsent := true
+	var prev float64
+	for i, v := range r.Values {
+		absent := r.IsAbsent[i] || (maxValue > 0 && v > maxValue)
+		switch {
+		case absent || prevAbsent:
+			result.IsAbsent[i] = true
+		case v >= prev:
+			result.Values[i] = (v - prev) / divisor
+		case maxValue > 0:
+			result.Values[i] = (maxValue - prev + v + 1) / divisor
+		default:
+			result.IsAbsent[i] = true
+		}
+		prev, prevAbsent = v, absent
+	}
+	return result, nil
+}
+
+// MovingAverage returns the average of the present values in the window of
+// the last points points at each point. The points before the first full
+// window are absent, since the data before the series is not available.
+func MovingAverage(r *carbonzipperpb3.FetchResponse, points int) (*carbonzipperpb3.FetchResponse, error) {
+	err := ensureAligned([]*carbonzipperpb3.FetchResponse{r})
+	if err != nil {
+		return nil, err
+	}
+	if points < 1 {
+		return nil, fmt.Errorf("invalid window size %d", points)
+	}
+	result := newLike(r, funcName("movingAverage", []*carbonzipperpb3.FetchResponse{r}, fmt.Sprintf("%d", points)))
+	var windowSum float64
+	var windowCount int
+	for i, v := range r.Values {
+		if !r.IsAbsent[i] {
+			windowSum += v
+			windowCount++
+		}
+		if j := i - points; j >= 0 && !r.IsAbsent[j] {
+			windowSum -= r.Values[j]
+			windowCount--
+		}
+		if i < points-1 || windowCount == 0 {
+			result.IsAbsent[i] = true
+			continue
+		}
+		result.Values[i] = windowSum / float64(windowCount)
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package series
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// nan marks absent points in test data.
+var nan = math.NaN()
+
+func newSeries(name string, values ...float64) *carbonzipperpb3.FetchResponse {
+	r := &carbonzipperpb3.FetchResponse{
+		Name:      name,
+		StartTime: 60,
+		StopTime:  60 + 60*int32(len(values)),
+		StepTime:  60,
+	}
+	for _, v := range values {
+		if math.IsNaN(v) {
+			r.Values = append(r.Values, 0)
+			r.IsAbsent = append(r.IsAbsent, true)
+		} else {
+			r.Values = append(r.Values, v)
+			r.IsAbsent = append(r.IsAbsent, false)
+		}
+	}
+	return r
+}
+
+func checkSeries(t *testing.T, got *carbonzipperpb3.FetchResponse, err error, want *carbonzipperpb3.FetchResponse) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected result,\ngot =%+v,\nwant=%+v", got, want)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	a := newSeries("a", 1, nan, 3, nan)
+	b := newSeries("b", 4, 5, nan, nan)
+	c := newSeries("c", 7, 2, 6, nan)
+
+	got, err := SumSeries(a, b, c)
+	checkSeries(t, got, err, newSeries("sumSeries(a,b,c)", 12, 7, 9, nan))
+	got, err = AverageSeries(a, b, c)
+	checkSeries(t, got, err, newSeries("averageSeries(a,b,c)", 4, 3.5, 4.5, nan))
+	got, err = MaxSeries(a, b, c)
+	checkSeries(t, got, err, newSeries("maxSeries(a,b,c)", 7, 5, 6, nan))
+	got, err = MinSeries(a, b, c)
+	checkSeries(t, got, err, newSeries("minSeries(a,b,c)", 1, 2, 3, nan))
+	got, err = PercentileOfSeries(50, a, b, c)
+	checkSeries(t, got, err, newSeries("percentileOfSeries(a,b,c,50)", 4, 5, 6, nan))
+	got, err = PercentileOfSeries(25, a, b, c)
+	checkSeries(t, got, err, newSeries("percentileOfSeries(a,b,c,25)", 1, 2, 3, nan))
+	got, err = PercentileOfSeries(100, a, b, c)
+	checkSeries(t, got, err, newSeries("percentileOfSeries(a,b,c,100)", 7, 5, 6, nan))
+
+	if a.Values[1] != 0 || !a.IsAbsent[1] {
+		t.Errorf("input must not be modified")
+	}
+
+	short := newSeries("short", 1, 2)
+	if _, err := SumSeries(a, short); err == nil {
+		t.Errorf("expected error for unaligned series")
+	}
+	if _, err := SumSeries(); err == nil {
+		t.Errorf("expected error for no series")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	r := newSeries("a", 1, 3, nan, 6, 10, 2, 5)
+
+	got, err := Derivative(r)
+	checkSeries(t, got, err, newSeries("derivative(a)", nan, 2, nan, nan, 4, -8, 3))
+	got, err = NonNegativeDerivative(r, 0)
+	checkSeries(t, got, err, newSeries("nonNegativeDerivative(a)", nan, 2, nan, nan, 4, nan, 3))
+	got, err = NonNegativeDerivative(r, 10)
+	checkSeries(t, got, err, newSeries("nonNegativeDerivative(a,10)", nan, 2, nan, nan, 4, 3, 3))
+	got, err = NonNegativeDerivative(r, 8)
+	checkSeries(t, got, err, newSeries("nonNegativeDerivative(a,8)", nan, 2, nan, nan, nan, nan, 3))
+	got, err = PerSecond(r, 0)
+	checkSeries(t, got, err, newSeries("perSecond(a)", nan, 2.0/60, nan, nan, 4.0/60, nan, 3.0/60))
+	got, err = MovingAverage(r, 3)
+	checkSeries(t, got, err, newSeries("movingAverage(a,3)", nan, nan, 2, 4.5, 8, 6, 17.0/3))
+
+	if _, err := MovingAverage(r, 0); err == nil {
+		t.Errorf("expected error for invalid window size")
+	}
+}
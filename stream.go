@@ -0,0 +1,228 @@
+package carbonx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+const maxFieldLen = 1<<31 - 1
+
+var errInvalidFieldLen = errors.New("invalid protobuf field length")
+
+// protoFieldReader reads top-level fields of a protobuf message one by one
+// so that a large repeated field can be decoded without holding the whole
+// message in memory.
+type protoFieldReader struct {
+	r *bufio.Reader
+
+	// maxLen is the maximum field length. Longer fields are rejected with
+	// errTooLarge, or errInvalidFieldLen if errTooLarge is nil, before the
+	// buffer for them is allocated.
+	maxLen      uint64
+	errTooLarge error
+}
+
+func newProtoFieldReader(r io.Reader) *protoFieldReader {
+	return &protoFieldReader{r: bufio.NewReader(r), maxLen: maxFieldLen}
+}
+
+// newResponseFieldReader returns a protoFieldReader for a response body
+// from endpoint which rejects fields larger than the maximum response size
+// of c.
+func (c *Client) newResponseFieldReader(body io.Reader, endpoint string) *protoFieldReader {
+	r := newProtoFieldReader(body)
+	if c.maxResponseSize > 0 && uint64(c.maxResponseSize) < r.maxLen {
+		r.maxLen = uint64(c.maxResponseSize)
+		r.errTooLarge = &ResponseTooLargeError{Endpoint: endpoint, Limit: c.maxResponseSize}
+	}
+	return r
+}
+
+// next returns the next field with length-delimited wire type, skipping
+// the fields of other wire types. It returns io.EOF at the end of r.
+func (r *protoFieldReader) next() (fieldNum int, data []byte, err error) {
+	for {
+		key, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return 0, nil, err
+		}
+		fieldNum, wireType := int(key>>3), int(key&0x7)
+		switch wireType {
+		case wireVarint:
+			_, err = binary.ReadUvarint(r.r)
+		case wireFixed64:
+			_, err = r.r.Discard(8)
+		case wireFixed32:
+			_, err = r.r.Discard(4)
+		case wireBytes:
+			var n uint64
+			n, err = binary.ReadUvarint(r.r)
+			if err != nil {
+				break
+			}
+			if n > r.maxLen {
+				if r.errTooLarge != nil {
+					return 0, nil, r.errTooLarge
+				}
+				return 0, nil, errInvalidFieldLen
+			}
+			data, err = readField(r.r, n)
+			if err == nil {
+				return fieldNum, data, nil
+			}
+		default:
+			return 0, nil, fmt.Errorf("unsupported protobuf wire type %d for field %d", wireType, fieldNum)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, nil, err
+		}
+	}
+}
+
+// fieldChunkSize is the size up to which a field is read into a buffer
+// allocated for its declared length. Longer fields are read into a buffer
+// which grows as the data arrives, so a corrupt length prefix does not
+// allocate more than the data actually in the stream.
+const fieldChunkSize = 64 << 10
+
+func readField(r io.Reader, n uint64) ([]byte, error) {
+	if n <= fieldChunkSize {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	var buf bytes.Buffer
+	m, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(m) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// FetchResponseDecoder decodes a protobuf encoded MultiFetchResponse one
+// FetchResponse at a time.
+type FetchResponseDecoder struct {
+	r *protoFieldReader
+}
+
+func NewFetchResponseDecoder(r io.Reader) *FetchResponseDecoder {
+	return &FetchResponseDecoder{r: newProtoFieldReader(r)}
+}
+
+// Decode returns the next FetchResponse. It returns io.EOF when there are
+// no more responses.
+func (d *FetchResponseDecoder) Decode() (*carbonzipperpb3.FetchResponse, error) {
+	for {
+		fieldNum, data, err := d.r.next()
+		if err != nil {
+			return nil, err
+		}
+		if fieldNum != 1 {
+			continue
+		}
+		m := &carbonzipperpb3.FetchResponse{}
+		err = m.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// RenderStream is like Render but calls fn for each series as it is
+// decoded from the response instead of returning all of them. If fn returns
+// an error, RenderStream stops and returns the error.
+func (c *Client) RenderStream(targets []string, from, until time.Time, fn func(r *carbonzipperpb3.FetchResponse) error) error {
+	return c.RenderStreamContext(context.Background(), targets, from, until, fn)
+}
+
+func (c *Client) RenderStreamContext(ctx context.Context, targets []string, from, until time.Time, fn func(r *carbonzipperpb3.FetchResponse) error) error {
+	if len(targets) == 0 {
+		return errors.New("no targets to render")
+	}
+	var body io.ReadCloser
+	err := c.retry(ctx, func() error {
+		var err error
+		body, err = c.open(ctx, renderEndpoint, renderQuery(targets, from, until))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := &FetchResponseDecoder{r: c.newResponseFieldReader(body, renderEndpoint)}
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = fn(m)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ListMetricsStream is like ListMetrics but calls fn for each metric name
+// as it is decoded from the response. If fn returns an error,
+// ListMetricsStream stops and returns the error.
+func (c *Client) ListMetricsStream(fn func(name string) error) error {
+	return c.ListMetricsStreamContext(context.Background(), fn)
+}
+
+func (c *Client) ListMetricsStreamContext(ctx context.Context, fn func(name string) error) error {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	var body io.ReadCloser
+	err := c.retry(ctx, func() error {
+		var err error
+		body, err = c.open(ctx, listEndpoint, query)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	r := c.newResponseFieldReader(body, listEndpoint)
+	for {
+		fieldNum, data, err := r.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fieldNum != 1 {
+			continue
+		}
+		err = fn(string(data))
+		if err != nil {
+			return err
+		}
+	}
+}
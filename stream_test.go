@@ -0,0 +1,65 @@
+package carbonx
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestFetchResponseDecoder(t *testing.T) {
+	want := carbonzipperpb3.MultiFetchResponse{
+		Metrics: []carbonzipperpb3.FetchResponse{
+			{Name: "a.b", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 0}, IsAbsent: []bool{false, true}},
+			{Name: "a.c", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{3, 4}, IsAbsent: []bool{false, false}},
+			{Name: "a.d"},
+		},
+	}
+	data, err := want.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewFetchResponseDecoder(bytes.NewReader(data))
+	for i := range want.Metrics {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.Metrics[i].String() {
+			t.Errorf("unexpected response, got=%s, want=%s", got, &want.Metrics[i])
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	dec = NewFetchResponseDecoder(bytes.NewReader(data[:len(data)-1]))
+	for {
+		_, err = dec.Decode()
+		if err != nil {
+			break
+		}
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for truncated data, got %v", err)
+	}
+}
+
+func TestFetchResponseDecoderFieldLength(t *testing.T) {
+	// A field 1 with a length prefix of 1 GiB followed by a few bytes.
+	data := []byte{0x0a, 0x80, 0x80, 0x80, 0x80, 0x04, 'a', 'b', 'c'}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := NewFetchResponseDecoder(bytes.NewReader(data)).Decode()
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for truncated field, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= 1<<20 {
+		t.Errorf("declared field length must not be allocated, allocated=%d", allocated)
+	}
+}
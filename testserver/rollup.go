@@ -0,0 +1,291 @@
+package testserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+)
+
+// Retention is an archive definition of a whisper file.
+type Retention struct {
+	SecondsPerPoint int
+	NumberOfPoints  int
+}
+
+// Duration returns the period covered by the archive.
+func (r Retention) Duration() time.Duration {
+	return time.Duration(r.SecondsPerPoint*r.NumberOfPoints) * time.Second
+}
+
+// ParseRetentions parses the retentions of the schema like whisper does,
+// for example "1s:5s,5s:15s,15s:60s" or "60:1440".
+func (c SchemaConfig) ParseRetentions() ([]Retention, error) {
+	var retentions []Retention
+	for _, def := range strings.Split(c.Retentions, ",") {
+		r, err := parseRetention(strings.TrimSpace(def))
+		if err != nil {
+			return nil, err
+		}
+		retentions = append(retentions, r)
+	}
+	for i := 1; i < len(retentions); i++ {
+		higher, lower := retentions[i-1], retentions[i]
+		if lower.SecondsPerPoint <= higher.SecondsPerPoint ||
+			lower.SecondsPerPoint%higher.SecondsPerPoint != 0 {
+			return nil, fmt.Errorf("precision of archive %d (%ds) must be a multiple of archive %d (%ds) in retentions %q",
+				i, lower.SecondsPerPoint, i-1, higher.SecondsPerPoint, c.Retentions)
+		}
+		if lower.Duration() <= higher.Duration() {
+			return nil, fmt.Errorf("archive %d must cover a longer period than archive %d in retentions %q",
+				i, i-1, c.Retentions)
+		}
+	}
+	return retentions, nil
+}
+
+func parseRetention(def string) (Retention, error) {
+	parts := strings.Split(def, ":")
+	if len(parts) != 2 {
+		return Retention{}, fmt.Errorf("invalid retention %q", def)
+	}
+	precision, err := parseRetentionValue(parts[0])
+	if err != nil || precision <= 0 {
+		return Retention{}, fmt.Errorf("invalid precision in retention %q", def)
+	}
+	points, err := parseRetentionValue(parts[1])
+	if err != nil || points <= 0 {
+		return Retention{}, fmt.Errorf("invalid points in retention %q", def)
+	}
+	// A duration with a unit is converted to the number of points.
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		points /= precision
+	}
+	return Retention{SecondsPerPoint: precision, NumberOfPoints: points}, nil
+}
+
+func parseRetentionValue(s string) (int, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		return strconv.Atoi(s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, err
+	}
+	unit := s[i:]
+	for _, u := range []struct {
+		name    string
+		seconds int
+	}{
+		{"seconds", 1},
+		{"minutes", 60},
+		{"hours", 60 * 60},
+		{"days", 24 * 60 * 60},
+		{"weeks", 7 * 24 * 60 * 60},
+		{"years", 365 * 24 * 60 * 60},
+	} {
+		if strings.HasPrefix(u.name, unit) {
+			return n * u.seconds, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid unit %q", unit)
+}
+
+// RollupArchive is the content of an archive predicted by SimulateRollup.
+// Points are sorted by timestamp and have aligned timestamps.
+type RollupArchive struct {
+	Retention Retention
+	Points    []carbonpb.Point
+}
+
+// SimulateRollup predicts the archives of a whisper file created with the
+// schema and the aggregation after the points of m are written at now to
+// an empty file.
+//
+// Like go-whisper's UpdateMany, each point is written to the highest
+// precision archive which covers its age and dropped if no archive covers
+// it. Among the points in the same slot, the newest one wins, and among the
+// points with the same timestamp, the last one in m wins. The slots written
+// to an archive are then propagated to the lower precision archives as long
+// as the ratio of known points is at least XFilesFactor for at least one of
+// them. Each archive is a ring buffer, so a slot is lost when a newer slot
+// at the same position is written. The result only contains the slots in
+// the retention period of each archive.
+func SimulateRollup(schema SchemaConfig, agg AggregationConfig, m *carbonpb.Metric, now time.Time) ([]RollupArchive, error) {
+	retentions, err := schema.ParseRetentions()
+	if err != nil {
+		return nil, err
+	}
+	aggregate, err := rollupAggregateFunc(agg.AggregationMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	nowUnix := int(now.Unix())
+	archives := make([]*ringArchive, len(retentions))
+	for i, r := range retentions {
+		archives[i] = &ringArchive{retention: r, slots: make(map[int]carbonpb.Point)}
+	}
+
+	// The points of each archive are written in chronological order and
+	// then propagated before the older points of the next archive.
+	points := append([]carbonpb.Point(nil), m.Points...)
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	for i, a := range archives {
+		var written []int
+		for _, p := range points {
+			if archiveIndex(retentions, nowUnix-int(p.Timestamp)) != i {
+				continue
+			}
+			slot := alignTimestamp(int(p.Timestamp), a.retention.SecondsPerPoint)
+			a.set(slot, p.Value)
+			written = append(written, slot)
+		}
+		if len(written) == 0 {
+			continue
+		}
+		for j := i + 1; j < len(archives); j++ {
+			seen := make(map[int]bool)
+			propagated := false
+			for _, slot := range written {
+				lowerSlot := alignTimestamp(slot, archives[j].retention.SecondsPerPoint)
+				if seen[lowerSlot] {
+					continue
+				}
+				seen[lowerSlot] = true
+				if propagate(archives[j-1], archives[j], lowerSlot, agg.XFilesFactor, aggregate) {
+					propagated = true
+				}
+			}
+			if !propagated {
+				break
+			}
+		}
+	}
+
+	result := make([]RollupArchive, len(retentions))
+	for i, archive := range archives {
+		result[i].Retention = archive.retention
+		oldest := nowUnix - archive.retention.SecondsPerPoint*archive.retention.NumberOfPoints
+		for _, p := range archive.slots {
+			if int(p.Timestamp) > oldest && int(p.Timestamp) <= nowUnix {
+				result[i].Points = append(result[i].Points, p)
+			}
+		}
+		sort.Slice(result[i].Points, func(a, b int) bool {
+			return result[i].Points[a].Timestamp < result[i].Points[b].Timestamp
+		})
+	}
+	return result, nil
+}
+
+// ringArchive holds the points of an archive by their positions in the
+// ring buffer of the whisper file.
+type ringArchive struct {
+	retention Retention
+	slots     map[int]carbonpb.Point
+}
+
+func (a *ringArchive) position(slot int) int {
+	return (slot / a.retention.SecondsPerPoint) % a.retention.NumberOfPoints
+}
+
+func (a *ringArchive) set(slot int, value float64) {
+	a.slots[a.position(slot)] = carbonpb.Point{Timestamp: uint32(slot), Value: value}
+}
+
+func (a *ringArchive) get(slot int) (float64, bool) {
+	p, ok := a.slots[a.position(slot)]
+	if !ok || int(p.Timestamp) != slot {
+		return 0, false
+	}
+	return p.Value, true
+}
+
+// archiveIndex returns the index of the highest precision archive which
+// covers age, or -1 if none covers it.
+func archiveIndex(retentions []Retention, age int) int {
+	for i, r := range retentions {
+		if age <= r.SecondsPerPoint*r.NumberOfPoints {
+			return i
+		}
+	}
+	return -1
+}
+
+func propagate(higher, lower *ringArchive, lowerSlot int, xFilesFactor float32, aggregate func(known []float64, total int) float64) bool {
+	higherStep := higher.retention.SecondsPerPoint
+	total := lower.retention.SecondsPerPoint / higherStep
+	var known []float64
+	for t := lowerSlot; t < lowerSlot+lower.retention.SecondsPerPoint; t += higherStep {
+		if v, ok := higher.get(t); ok {
+			known = append(known, v)
+		}
+	}
+	if len(known) == 0 || float32(len(known))/float32(total) < xFilesFactor {
+		return false
+	}
+	lower.set(lowerSlot, aggregate(known, total))
+	return true
+}
+
+func alignTimestamp(t, step int) int {
+	return t - t%step
+}
+
+func rollupAggregateFunc(method string) (func(known []float64, total int) float64, error) {
+	switch method {
+	case "average", "avg", "":
+		return func(known []float64, total int) float64 {
+			return sum(known) / float64(len(known))
+		}, nil
+	case "avg_zero":
+		return func(known []float64, total int) float64 {
+			return sum(known) / float64(total)
+		}, nil
+	case "sum":
+		return func(known []float64, total int) float64 {
+			return sum(known)
+		}, nil
+	case "last":
+		return func(known []float64, total int) float64 {
+			return known[len(known)-1]
+		}, nil
+	case "max":
+		return func(known []float64, total int) float64 {
+			m := known[0]
+			for _, v := range known[1:] {
+				if v > m {
+					m = v
+				}
+			}
+			return m
+		}, nil
+	case "min":
+		return func(known []float64, total int) float64 {
+			m := known[0]
+			for _, v := range known[1:] {
+				if v < m {
+					m = v
+				}
+			}
+			return m
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported aggregation method %q", method)
+	}
+}
+
+func sum(values []float64) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
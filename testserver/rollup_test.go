@@ -0,0 +1,119 @@
+package testserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx/carbonpb"
+)
+
+func pt(timestamp uint32, value float64) carbonpb.Point {
+	return carbonpb.Point{Timestamp: timestamp, Value: value}
+}
+
+func TestParseRetentions(t *testing.T) {
+	testCases := []struct {
+		retentions string
+		want       []Retention
+	}{
+		{"1s:5s,5s:15s,15s:60s", []Retention{{1, 5}, {5, 3}, {15, 4}}},
+		{"60:1440,1h:7d", []Retention{{60, 1440}, {3600, 168}}},
+		{"10sec:6min, 1m:1y", []Retention{{10, 36}, {60, 525600}}},
+	}
+	for _, c := range testCases {
+		got, err := SchemaConfig{Retentions: c.retentions}.ParseRetentions()
+		if err != nil {
+			t.Errorf("retentions=%q: %v", c.retentions, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("retentions=%q: got=%+v, want=%+v", c.retentions, got, c.want)
+		}
+	}
+
+	for _, retentions := range []string{"", "1s", "1x:5s", "0:5", "5s:1m,2s:1h", "1s:1m,5s:30s"} {
+		if _, err := (SchemaConfig{Retentions: retentions}).ParseRetentions(); err == nil {
+			t.Errorf("retentions=%q: expected error", retentions)
+		}
+	}
+}
+
+func TestSimulateRollup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	schema := SchemaConfig{Retentions: "1s:5s,5s:15s,15s:60s"}
+	m := &carbonpb.Metric{Metric: "test.rollup"}
+	for ts := uint32(990); ts <= 1000; ts++ {
+		m.Points = append(m.Points, carbonpb.Point{Timestamp: ts, Value: float64(ts - 989)})
+	}
+
+	got, err := SimulateRollup(schema, AggregationConfig{AggregationMethod: "sum"}, m, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The point of age 5 is written to the 1s archive, which only has 5
+	// slots, so the slot 995 is overwritten by 1000 before it is propagated.
+	// The points of ages 6 to 10 are written directly to the 5s archive,
+	// where the newest point in the slot 990 wins.
+	want := []RollupArchive{
+		{Retention{1, 5}, []carbonpb.Point{pt(996, 7), pt(997, 8), pt(998, 9), pt(999, 10), pt(1000, 11)}},
+		{Retention{5, 3}, []carbonpb.Point{pt(990, 5), pt(995, 34), pt(1000, 11)}},
+		{Retention{15, 4}, []carbonpb.Point{pt(990, 50)}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected archives,\ngot =%+v,\nwant=%+v", got, want)
+	}
+}
+
+func TestSimulateRollupSameTimestamp(t *testing.T) {
+	now := time.Unix(1000, 0)
+	schema := SchemaConfig{Retentions: "1:4"}
+	m := &carbonpb.Metric{
+		Metric: "test.rollup",
+		Points: []carbonpb.Point{pt(999, 3), pt(998, 1), pt(999, 2)},
+	}
+	got, err := SimulateRollup(schema, AggregationConfig{AggregationMethod: "sum"}, m, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []carbonpb.Point{pt(998, 1), pt(999, 2)}; !reflect.DeepEqual(got[0].Points, want) {
+		t.Errorf("the last point with the same timestamp must win, got=%+v, want=%+v", got[0].Points, want)
+	}
+}
+
+func TestSimulateRollupAggregationMethods(t *testing.T) {
+	now := time.Unix(1000, 0)
+	schema := SchemaConfig{Retentions: "1:4,4:4"}
+	m := &carbonpb.Metric{
+		Metric: "test.rollup",
+		Points: []carbonpb.Point{pt(997, 2), pt(999, 6)},
+	}
+	testCases := []struct {
+		method       string
+		xFilesFactor float32
+		want         []carbonpb.Point
+	}{
+		{"average", 0.5, []carbonpb.Point{pt(996, 4)}},
+		{"avg", 0.5, []carbonpb.Point{pt(996, 4)}},
+		{"avg_zero", 0.5, []carbonpb.Point{pt(996, 2)}},
+		{"sum", 0.5, []carbonpb.Point{pt(996, 8)}},
+		{"last", 0.5, []carbonpb.Point{pt(996, 6)}},
+		{"max", 0.5, []carbonpb.Point{pt(996, 6)}},
+		{"min", 0.5, []carbonpb.Point{pt(996, 2)}},
+		{"average", 0.75, nil},
+	}
+	for _, c := range testCases {
+		agg := AggregationConfig{AggregationMethod: c.method, XFilesFactor: c.xFilesFactor}
+		got, err := SimulateRollup(schema, agg, m, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got[1].Points, c.want) {
+			t.Errorf("method=%s, xFilesFactor=%g: got=%+v, want=%+v", c.method, c.xFilesFactor, got[1].Points, c.want)
+		}
+	}
+
+	if _, err := SimulateRollup(schema, AggregationConfig{AggregationMethod: "median"}, m, now); err == nil {
+		t.Errorf("expected error for unsupported aggregation method")
+	}
+}
@@ -0,0 +1,300 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+// WalkOutput selects the nodes which WalkMetrics passes to the callback.
+type WalkOutput int
+
+const (
+	WalkAll WalkOutput = iota
+	WalkLeavesOnly
+	WalkBranchesOnly
+)
+
+// WalkOptions configures WalkMetrics.
+type WalkOptions struct {
+	// Concurrency is the number of workers and hence the maximum number of
+	// /metrics/find/ requests in flight. Values less than 1 mean 1.
+	Concurrency int
+
+	// ConcurrentCallbacks allows the FindMetricFunc to be called from
+	// several goroutines at once. When false, the calls are serialized.
+	ConcurrentCallbacks bool
+
+	// MaxDepth limits how deep the walk descends. The children of the
+	// starting node are at depth 1. Zero means no limit.
+	MaxDepth int
+
+	// Include and Exclude filter the nodes by the last component of their
+	// names. The glob at index i applies to the nodes at depth i+1, and a
+	// missing or empty glob imposes no restriction. Include globs are sent
+	// to the server in place of "*" so the carbonserver glob syntax such as
+	// {a,b} can be used. A branch with no children matching its Include
+	// glob is treated as having no children rather than as an error.
+	// Exclude globs are matched with path.Match. Excluded branches are not
+	// descended.
+	Include []string
+	Exclude []string
+
+	// Output selects the nodes passed to the callback. Branches are
+	// descended even if they are not passed to the callback.
+	Output WalkOutput
+}
+
+func (o *WalkOptions) include(depth int) string {
+	if depth-1 < len(o.Include) && o.Include[depth-1] != "" {
+		return o.Include[depth-1]
+	}
+	return "*"
+}
+
+func (o *WalkOptions) excluded(name string, depth int) bool {
+	if depth-1 >= len(o.Exclude) || o.Exclude[depth-1] == "" {
+		return false
+	}
+	ok, _ := path.Match(o.Exclude[depth-1], name[strings.LastIndexByte(name, '.')+1:])
+	return ok
+}
+
+func (o *WalkOptions) output(isLeaf bool) bool {
+	switch o.Output {
+	case WalkLeavesOnly:
+		return isLeaf
+	case WalkBranchesOnly:
+		return !isLeaf
+	default:
+		return true
+	}
+}
+
+// WalkMetrics walks the metric tree under name like FindMetricsRecursive,
+// but sends up to opts.Concurrency requests in parallel. The order of the
+// callback calls is not defined except that a node is always visited before
+// its children. The first error other than SkipDir returned by findMetricFn
+// cancels the walk, and WalkMetrics returns it unless it is SkipAll.
+func (c *Client) WalkMetrics(ctx context.Context, name string, opts *WalkOptions, findMetricFn FindMetricFunc) error {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{
+		client: c,
+		opts:   opts,
+		fn:     findMetricFn,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go func() {
+		// Wake up the idle workers when the walk is canceled.
+		<-ctx.Done()
+		w.mu.Lock()
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}()
+	w.push(walkTask{name: name, depth: 1})
+	w.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go w.work()
+	}
+	w.wg.Wait()
+
+	if w.err == SkipAll {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+	return ctx.Err()
+}
+
+// walker runs a fixed number of workers which take branches to visit from
+// a shared stack. Using a stack rather than a queue keeps the number of
+// pending branches proportional to the depth of the tree.
+type walker struct {
+	client *Client
+	opts   *WalkOptions
+	fn     FindMetricFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	tasks []walkTask
+	// pending is the number of tasks which are queued or being visited.
+	pending int
+
+	callMu sync.Mutex
+
+	errOnce sync.Once
+	err     error
+}
+
+type walkTask struct {
+	name  string
+	depth int
+}
+
+func (w *walker) push(t walkTask) {
+	w.mu.Lock()
+	w.tasks = append(w.tasks, t)
+	w.pending++
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// pop returns the next task. It returns false when the walk is done or
+// canceled.
+func (w *walker) pop() (walkTask, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.tasks) == 0 && w.pending > 0 && w.ctx.Err() == nil {
+		w.cond.Wait()
+	}
+	if len(w.tasks) == 0 || w.ctx.Err() != nil {
+		return walkTask{}, false
+	}
+	t := w.tasks[len(w.tasks)-1]
+	w.tasks = w.tasks[:len(w.tasks)-1]
+	return t, true
+}
+
+func (w *walker) done() {
+	w.mu.Lock()
+	w.pending--
+	if w.pending == 0 {
+		w.cond.Broadcast()
+	}
+	w.mu.Unlock()
+}
+
+func (w *walker) work() {
+	defer w.wg.Done()
+	for {
+		t, ok := w.pop()
+		if !ok {
+			return
+		}
+		w.walk(t.name, t.depth)
+		w.done()
+	}
+}
+
+// walk visits the children of name, which are at depth.
+func (w *walker) walk(name string, depth int) {
+	resp, err := w.client.FindMetricsContext(w.ctx, joinMetricName(name, w.opts.include(depth)))
+	if w.ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		// go-carbon returns 404 for a branch which has no children
+		// matching the Include glob.
+		if depth > 1 && w.opts.include(depth) != "*" && errors.Is(err, ErrNotFound) {
+			return
+		}
+		err = w.call(name, false, err)
+		if err != nil && err != SkipDir {
+			w.fail(err)
+		}
+		return
+	}
+
+	for _, m := range resp.Matches {
+		if w.ctx.Err() != nil {
+			return
+		}
+		if w.opts.excluded(m.Path, depth) {
+			continue
+		}
+		if w.opts.output(m.IsLeaf) {
+			err = w.call(m.Path, m.IsLeaf, nil)
+			if err != nil {
+				if err == SkipDir {
+					continue
+				}
+				w.fail(err)
+				return
+			}
+		}
+		if !m.IsLeaf && (w.opts.MaxDepth <= 0 || depth < w.opts.MaxDepth) {
+			w.push(walkTask{name: m.Path, depth: depth + 1})
+		}
+	}
+}
+
+func (w *walker) call(name string, isLeaf bool, err error) error {
+	if !w.opts.ConcurrentCallbacks {
+		w.callMu.Lock()
+		defer w.callMu.Unlock()
+		// Do not call fn after the walk has been stopped while waiting.
+		if w.ctx.Err() != nil {
+			return nil
+		}
+	}
+	return w.fn(name, isLeaf, err)
+}
+
+func (w *walker) fail(err error) {
+	w.errOnce.Do(func() {
+		w.err = err
+		w.cancel()
+	})
+}
+
+func childPattern(name string) string {
+	return joinMetricName(name, "*")
+}
+
+func joinMetricName(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+// WalkEntry is a node or an error sent by WalkMetricsChan.
+type WalkEntry struct {
+	Name   string
+	IsLeaf bool
+	Err    error
+}
+
+// WalkMetricsChan is like WalkMetrics but sends the nodes to the returned
+// channel instead of calling a callback, so the walk can be consumed with a
+// for range loop. Errors from /metrics/find/ are sent as entries with Err
+// set and the walk continues. An error which stops the walk is sent as the
+// last entry. The channel is closed when the walk is done. A consumer which
+// stops reading early must cancel ctx to release the walk.
+func (c *Client) WalkMetricsChan(ctx context.Context, name string, opts *WalkOptions) <-chan WalkEntry {
+	ch := make(chan WalkEntry)
+	go func() {
+		defer close(ch)
+		err := c.WalkMetrics(ctx, name, opts, func(name string, isLeaf bool, err error) error {
+			select {
+			case ch <- WalkEntry{Name: name, IsLeaf: isLeaf, Err: err}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			select {
+			case ch <- WalkEntry{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
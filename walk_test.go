@@ -0,0 +1,241 @@
+package carbonx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newFakeTree() []string {
+	var leaves []string
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			for k := 0; k < 3; k++ {
+				leaves = append(leaves, fmt.Sprintf("n%d.n%d.leaf%d", i, j, k))
+			}
+		}
+	}
+	return leaves
+}
+
+func TestWalkMetrics(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := &fakeCarbonserver{leaves: newFakeTree()}
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	var want []string
+	err := c.FindMetricsRecursive("", func(name string, isLeaf bool, err error) error {
+		if err != nil {
+			return err
+		}
+		want = append(want, fmt.Sprintf("%s:%v", name, isLeaf))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(want)
+
+	const concurrency = 3
+	var got []string
+	atomic.StoreInt32(&maxInFlight, 0)
+	err = c.WalkMetrics(context.Background(), "", &WalkOptions{Concurrency: concurrency},
+		func(name string, isLeaf bool, err error) error {
+			if err != nil {
+				return err
+			}
+			got = append(got, fmt.Sprintf("%s:%v", name, isLeaf))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected walk result,\ngot =%v,\nwant=%v", got, want)
+	}
+	if m := atomic.LoadInt32(&maxInFlight); m > concurrency {
+		t.Errorf("too many requests in flight, got=%d, max=%d", m, concurrency)
+	}
+}
+
+func TestWalkMetricsGoroutines(t *testing.T) {
+	var leaves []string
+	for i := 0; i < 500; i++ {
+		leaves = append(leaves, fmt.Sprintf("n%d.leaf", i))
+	}
+	c, ts := newFakeClient(t, &fakeCarbonserver{leaves: leaves})
+	defer ts.Close()
+
+	base := runtime.NumGoroutine()
+	var maxGoroutines int
+	err := c.WalkMetrics(context.Background(), "", &WalkOptions{Concurrency: 2},
+		func(name string, isLeaf bool, err error) error {
+			if err != nil {
+				return err
+			}
+			if n := runtime.NumGoroutine(); n > maxGoroutines {
+				maxGoroutines = n
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxGoroutines-base > 50 {
+		t.Errorf("too many goroutines for %d branches, got=%d, base=%d", len(leaves), maxGoroutines, base)
+	}
+}
+
+func TestWalkMetricsSkipDirAndError(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{leaves: newFakeTree()})
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var leaves int
+	err := c.WalkMetrics(context.Background(), "", &WalkOptions{Concurrency: 4, ConcurrentCallbacks: true},
+		func(name string, isLeaf bool, err error) error {
+			if err != nil {
+				return err
+			}
+			if name == "n1" || strings.HasSuffix(name, ".n1") {
+				return SkipDir
+			}
+			if isLeaf {
+				mu.Lock()
+				leaves++
+				mu.Unlock()
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4 * 4 * 3; leaves != want {
+		t.Errorf("unexpected leaf count, got=%d, want=%d", leaves, want)
+	}
+
+	wantErr := errors.New("stop")
+	var calls int
+	err = c.WalkMetrics(context.Background(), "", &WalkOptions{Concurrency: 4},
+		func(name string, isLeaf bool, err error) error {
+			calls++
+			if name == "n2.n2" {
+				return wantErr
+			}
+			return nil
+		})
+	if err != wantErr {
+		t.Errorf("unexpected error, got=%v, want=%v", err, wantErr)
+	}
+	if total := 5 + 5*5 + 5*5*3; calls >= total {
+		t.Errorf("walk was not canceled, calls=%d", calls)
+	}
+}
+
+func TestWalkMetricsOptions(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{leaves: newFakeTree()})
+	defer ts.Close()
+
+	testCases := []struct {
+		opts WalkOptions
+		want string
+	}{
+		{
+			opts: WalkOptions{MaxDepth: 1},
+			want: "n0,n1,n2,n3,n4",
+		},
+		{
+			opts: WalkOptions{MaxDepth: 2, Include: []string{"n1"}, Exclude: []string{"", "n[0-2]"}},
+			want: "n1,n1.n3,n1.n4",
+		},
+		{
+			opts: WalkOptions{Include: []string{"n4", "n0"}, Output: WalkLeavesOnly},
+			want: "n4.n0.leaf0,n4.n0.leaf1,n4.n0.leaf2",
+		},
+		{
+			opts: WalkOptions{Exclude: []string{"n[1-4]", "n[1-4]"}, Output: WalkBranchesOnly},
+			want: "n0,n0.n0",
+		},
+	}
+	for i, tc := range testCases {
+		var names []string
+		for e := range c.WalkMetricsChan(context.Background(), "", &tc.opts) {
+			if e.Err != nil {
+				t.Fatal(e.Err)
+			}
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != tc.want {
+			t.Errorf("case %d: unexpected names, got=%s, want=%s", i, got, tc.want)
+		}
+	}
+}
+
+func TestWalkMetricsIncludeNoMatch(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{
+		leaves: []string{"a.x.leaf", "a.y.leaf", "b.y.leaf", "c.z.leaf"},
+	})
+	defer ts.Close()
+
+	var names []string
+	opts := &WalkOptions{Include: []string{"", "{x,z}"}}
+	for e := range c.WalkMetricsChan(context.Background(), "", opts) {
+		if e.Err != nil {
+			t.Fatalf("unexpected error entry for %s: %v", e.Name, e.Err)
+		}
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, ","), "a,a.x,a.x.leaf,b,c,c.z,c.z.leaf"; got != want {
+		t.Errorf("unexpected names, got=%s, want=%s", got, want)
+	}
+}
+
+func TestSkipAll(t *testing.T) {
+	c, ts := newFakeClient(t, &fakeCarbonserver{leaves: newFakeTree()})
+	defer ts.Close()
+
+	var calls int
+	err := c.FindMetricsRecursive("", func(name string, isLeaf bool, err error) error {
+		calls++
+		if name == "n0.n0.leaf1" {
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil || calls != 4 {
+		t.Errorf("unexpected result of FindMetricsRecursive, err=%v, calls=%d", err, calls)
+	}
+
+	calls = 0
+	err = c.WalkMetrics(context.Background(), "n3", nil, func(name string, isLeaf bool, err error) error {
+		calls++
+		if name == "n3.n2" {
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil || calls >= 5+5*3 {
+		t.Errorf("unexpected result of WalkMetrics, err=%v, calls=%d", err, calls)
+	}
+}
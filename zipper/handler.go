@@ -0,0 +1,169 @@
+// Package zipper provides an HTTP handler which serves the carbonserver
+// endpoints by fanning out requests to several carbonservers with
+// carbonx.MultiClient and merging the responses, like carbonzipper.
+package zipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hnakamur/carbonx"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+type Handler struct {
+	client *carbonx.MultiClient
+	mux    *http.ServeMux
+
+	// ErrorLog logs the errors from backends. If nil, the standard
+	// logger is used.
+	ErrorLog *log.Logger
+}
+
+func NewHandler(client *carbonx.MultiClient) *Handler {
+	h := &Handler{
+		client: client,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/metrics/find/", h.serveFind)
+	h.mux.HandleFunc("/info/", h.serveInfo)
+	h.mux.HandleFunc("/render/", h.serveRender)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveFind(w http.ResponseWriter, r *http.Request) {
+	if !h.checkFormat(w, r) {
+		return
+	}
+	query := r.FormValue("query")
+	if query == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+	resp, err := h.client.FindMetricsContext(r.Context(), query)
+	if !h.handleError(w, r, resp != nil, err) {
+		return
+	}
+	writeProtobuf(w, resp)
+}
+
+func (h *Handler) serveInfo(w http.ResponseWriter, r *http.Request) {
+	if !h.checkFormat(w, r) {
+		return
+	}
+	target := r.FormValue("target")
+	if target == "" {
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+	resp, err := h.client.GetMetricInfoContext(r.Context(), target)
+	if !h.handleError(w, r, resp != nil, err) {
+		return
+	}
+	writeProtobuf(w, resp)
+}
+
+func (h *Handler) serveRender(w http.ResponseWriter, r *http.Request) {
+	if !h.checkFormat(w, r) {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targets := r.Form["target"]
+	if len(targets) == 0 {
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+	from, err := parseUnixTime(r.Form.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseUnixTime(r.Form.Get("until"))
+	if err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics, err := h.client.RenderContext(r.Context(), targets, from, until)
+	if !h.handleError(w, r, metrics != nil, err) {
+		return
+	}
+	writeProtobuf(w, &carbonzipperpb3.MultiFetchResponse{Metrics: metrics})
+}
+
+func (h *Handler) checkFormat(w http.ResponseWriter, r *http.Request) bool {
+	switch format := r.FormValue("format"); format {
+	case "protobuf", "protobuf3":
+		return true
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %q", format), http.StatusBadRequest)
+		return false
+	}
+}
+
+// handleError writes an error response and returns false if the request
+// cannot be served. Partial failures of backends are only logged when some
+// backends have returned a result.
+func (h *Handler) handleError(w http.ResponseWriter, r *http.Request, hasResult bool, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, carbonx.ErrNotFound) {
+		http.NotFound(w, r)
+		return false
+	}
+	h.logf("%s %s: %s", r.URL.Path, r.URL.RawQuery, err)
+	var backendErrs *carbonx.BackendErrors
+	if hasResult && errors.As(err, &backendErrs) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+	return false
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func parseUnixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+type protobufMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+func writeProtobuf(w http.ResponseWriter, m protobufMarshaler) {
+	data, err := m.Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/protobuf")
+	w.Write(data)
+}
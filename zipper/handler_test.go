@@ -0,0 +1,225 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/carbonx"
+	"github.com/hnakamur/carbonx/carbonpb"
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+	"github.com/hnakamur/carbonx/sender"
+	"github.com/hnakamur/carbonx/testserver"
+	"github.com/hnakamur/freeport"
+)
+
+type fakeBackend struct {
+	glob   carbonzipperpb3.GlobResponse
+	info   carbonzipperpb3.InfoResponse
+	series carbonzipperpb3.FetchResponse
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/metrics/find/":
+		writeProtobuf(w, &b.glob)
+	case "/info/":
+		writeProtobuf(w, &b.info)
+	case "/render/":
+		writeProtobuf(w, &carbonzipperpb3.MultiFetchResponse{
+			Metrics: []carbonzipperpb3.FetchResponse{b.series},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newZipper(t *testing.T, backendURLs ...string) (*carbonx.Client, *httptest.Server) {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	var clients []*carbonx.Client
+	for _, u := range backendURLs {
+		c, err := carbonx.NewClient(u, httpClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	ts := httptest.NewServer(NewHandler(carbonx.NewMultiClient(clients...)))
+	c, err := carbonx.NewClient(ts.URL, httpClient)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts
+}
+
+func TestHandler(t *testing.T) {
+	b1 := httptest.NewServer(&fakeBackend{
+		glob: carbonzipperpb3.GlobResponse{Name: "a.*", Matches: []carbonzipperpb3.GlobMatch{{Path: "a.b", IsLeaf: true}}},
+		info: carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "sum"},
+		series: carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 180, StepTime: 60,
+			Values: []float64{1, 0}, IsAbsent: []bool{false, true}},
+	})
+	defer b1.Close()
+	b2 := httptest.NewServer(&fakeBackend{
+		glob: carbonzipperpb3.GlobResponse{Name: "a.*", Matches: []carbonzipperpb3.GlobMatch{{Path: "a.b", IsLeaf: true}, {Path: "a.c", IsLeaf: false}}},
+		info: carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "average"},
+		series: carbonzipperpb3.FetchResponse{Name: "a.b", StartTime: 60, StopTime: 180, StepTime: 60,
+			Values: []float64{0, 2}, IsAbsent: []bool{true, false}},
+	})
+	defer b2.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	c, ts := newZipper(t, b1.URL, b2.URL, down.URL)
+	defer ts.Close()
+	ts.Config.Handler.(*Handler).ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	glob, err := c.FindMetrics("a.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []carbonzipperpb3.GlobMatch{{Path: "a.b", IsLeaf: true}, {Path: "a.c", IsLeaf: false}}
+	if !reflect.DeepEqual(glob.Matches, want) {
+		t.Errorf("unexpected matches, got=%v, want=%v", glob.Matches, want)
+	}
+
+	info, err := c.GetZipperMetricInfo("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Responses) != 2 || info.Responses[0].Server != b1.URL || info.Responses[1].Server != b2.URL {
+		t.Errorf("unexpected info, got=%+v", info)
+	}
+	if mismatches := carbonx.FindInfoMismatches(info); len(mismatches) != 1 {
+		t.Errorf("expected one mismatch, got=%+v", mismatches)
+	}
+
+	r, err := c.FetchData("a.b", time.Unix(60, 0), time.Unix(180, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Values, []float64{1, 2}) || !reflect.DeepEqual(r.IsAbsent, []bool{false, false}) {
+		t.Errorf("unexpected merged series, got=%+v", r)
+	}
+
+	c, ts2 := newZipper(t, down.URL)
+	defer ts2.Close()
+	ts2.Config.Handler.(*Handler).ErrorLog = log.New(ioutil.Discard, "", 0)
+	_, err = c.FindMetrics("a.*")
+	var httpErr *carbonx.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected %d, got %v", http.StatusBadGateway, err)
+	}
+}
+
+func TestHandlerWithCarbonServers(t *testing.T) {
+	if _, err := exec.LookPath("go-carbon"); err != nil {
+		t.Skip("go-carbon not found in $PATH")
+	}
+
+	var urls []string
+	var receivers []string
+	for i := 0; i < 2; i++ {
+		rootDir, err := ioutil.TempDir("", "carbontest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(rootDir)
+
+		ports, err := freeport.GetFreePorts(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := &testserver.Carbon{
+			RootDir:            rootDir,
+			TCPListen:          fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			CarbonserverListen: fmt.Sprintf("127.0.0.1:%d", ports[1]),
+			Schemas: []testserver.SchemaConfig{
+				{Name: "default", Pattern: "\\.*", Retentions: "1s:60s"},
+			},
+			Aggregations: []testserver.AggregationConfig{
+				{Name: "default", Pattern: "\\.*", AggregationMethod: "sum"},
+			},
+		}
+		err = ts.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Kill()
+		err = testserver.WaitTCPPortConnectable(ts.TCPListen, 5, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, "http://"+ts.CarbonserverListen)
+		receivers = append(receivers, ts.TCPListen)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	for i, addr := range receivers {
+		s, err := sender.NewTCPSender(addr, sender.NewTextMetricsMarshaler())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.ConnectSendClose([]*carbonpb.Metric{{
+			Metric: "test.zipper",
+			Points: []carbonpb.Point{{
+				Timestamp: uint32(now.Add(-time.Duration(i) * time.Second).Unix()),
+				Value:     float64(i + 1),
+			}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// MultiClient treats a 404 from one backend as success, so wait until
+	// each backend has flushed its point before querying the zipper.
+	for _, u := range urls {
+		_, err := withRetry(t, u).FetchData("test.zipper", now.Add(-2*time.Second), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, zts := newZipper(t, urls...)
+	defer zts.Close()
+	c := withRetry(t, zts.URL)
+	r, err := c.FetchData("test.zipper", now.Add(-2*time.Second), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var present int
+	for _, absent := range r.IsAbsent {
+		if !absent {
+			present++
+		}
+	}
+	if present != 2 {
+		t.Errorf("expected points from both servers, got=%+v", r)
+	}
+}
+
+func withRetry(t *testing.T, serverURL string) *carbonx.Client {
+	c, err := carbonx.NewClient(serverURL, &http.Client{Timeout: 5 * time.Second},
+		carbonx.WithRetryPolicy(carbonx.RetryPolicy{
+			Attempts:       10,
+			InitialBackoff: 100 * time.Millisecond,
+			Multiplier:     1,
+			RetryNotFound:  true,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
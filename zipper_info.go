@@ -0,0 +1,113 @@
+package carbonx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+// GetZipperMetricInfo is like GetMetricInfo but for carbonzipper, which
+// returns the info from each backend server.
+func (c *Client) GetZipperMetricInfo(name string) (*carbonzipperpb3.ZipperInfoResponse, error) {
+	return c.GetZipperMetricInfoContext(context.Background(), name)
+}
+
+func (c *Client) GetZipperMetricInfoContext(ctx context.Context, name string) (*carbonzipperpb3.ZipperInfoResponse, error) {
+	query := url.Values{}
+	query.Set("format", "protobuf")
+	query.Set("target", name)
+	info := &carbonzipperpb3.ZipperInfoResponse{}
+	err := c.retry(ctx, func() error {
+		data, err := c.get(ctx, infoEndpoint, query)
+		if err != nil {
+			return err
+		}
+		info.Reset()
+		return info.Unmarshal(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// InfoMismatch describes how the info from a backend server differs from
+// the info shared by most of the servers.
+type InfoMismatch struct {
+	Server  string
+	Reasons []string
+}
+
+// FindInfoMismatches returns the servers in resp whose retentions,
+// aggregation method or xFilesFactor differ from those of the majority of
+// the servers. Ties are broken in favor of the server which comes first.
+func FindInfoMismatches(resp *carbonzipperpb3.ZipperInfoResponse) []InfoMismatch {
+	counts := make(map[string]int)
+	var ref *carbonzipperpb3.InfoResponse
+	var refCount int
+	for _, r := range resp.Responses {
+		if r.Info == nil {
+			continue
+		}
+		key := infoSignature(r.Info)
+		counts[key]++
+		if counts[key] > refCount {
+			ref = r.Info
+			refCount = counts[key]
+		}
+	}
+
+	var mismatches []InfoMismatch
+	for _, r := range resp.Responses {
+		if r.Info == nil {
+			mismatches = append(mismatches, InfoMismatch{
+				Server:  r.Server,
+				Reasons: []string{"no info"},
+			})
+			continue
+		}
+		reasons := compareInfo(ref, r.Info)
+		if len(reasons) > 0 {
+			mismatches = append(mismatches, InfoMismatch{
+				Server:  r.Server,
+				Reasons: reasons,
+			})
+		}
+	}
+	return mismatches
+}
+
+func infoSignature(info *carbonzipperpb3.InfoResponse) string {
+	return fmt.Sprintf("%s %g %s", info.AggregationMethod, info.XFilesFactor, formatRetentions(info.Retentions))
+}
+
+func compareInfo(want, got *carbonzipperpb3.InfoResponse) []string {
+	var reasons []string
+	if got.AggregationMethod != want.AggregationMethod {
+		reasons = append(reasons, fmt.Sprintf("aggregationMethod=%s, want=%s",
+			got.AggregationMethod, want.AggregationMethod))
+	}
+	if got.XFilesFactor != want.XFilesFactor {
+		reasons = append(reasons, fmt.Sprintf("xFilesFactor=%g, want=%g",
+			got.XFilesFactor, want.XFilesFactor))
+	}
+	if g, w := formatRetentions(got.Retentions), formatRetentions(want.Retentions); g != w {
+		reasons = append(reasons, fmt.Sprintf("retentions=%s, want=%s", g, w))
+	}
+	return reasons
+}
+
+// formatRetentions formats retentions like 1s:5s,5s:15s, the format used in
+// storage-schemas.conf, with both values in seconds.
+func formatRetentions(retentions []carbonzipperpb3.Retention) string {
+	var b []byte
+	for i, r := range retentions {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, fmt.Sprintf("%ds:%ds", r.SecondsPerPoint, r.SecondsPerPoint*r.NumberOfPoints)...)
+	}
+	return string(b)
+}
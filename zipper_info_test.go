@@ -0,0 +1,47 @@
+package carbonx
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/carbonx/carbonzipperpb3"
+)
+
+func TestGetZipperMetricInfo(t *testing.T) {
+	retentions := []carbonzipperpb3.Retention{
+		{SecondsPerPoint: 1, NumberOfPoints: 5},
+		{SecondsPerPoint: 5, NumberOfPoints: 3},
+	}
+	want := &carbonzipperpb3.ZipperInfoResponse{
+		Responses: []carbonzipperpb3.ServerInfoResponse{
+			{Server: "s1", Info: &carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "sum", Retentions: retentions}},
+			{Server: "s2", Info: &carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "average", Retentions: retentions}},
+			{Server: "s3", Info: &carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "sum", Retentions: retentions}},
+			{Server: "s4", Info: &carbonzipperpb3.InfoResponse{Name: "a.b", AggregationMethod: "sum", XFilesFactor: 0.5, Retentions: retentions[:1]}},
+			{Server: "s5"},
+		},
+	}
+	c, ts := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProtobuf(w, want)
+	}))
+	defer ts.Close()
+
+	got, err := c.GetZipperMetricInfo("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Responses) != len(want.Responses) {
+		t.Fatalf("unexpected response count, got=%d, want=%d", len(got.Responses), len(want.Responses))
+	}
+
+	mismatches := FindInfoMismatches(got)
+	wantMismatches := []InfoMismatch{
+		{Server: "s2", Reasons: []string{"aggregationMethod=average, want=sum"}},
+		{Server: "s4", Reasons: []string{"xFilesFactor=0.5, want=0", "retentions=1s:5s, want=1s:5s,5s:15s"}},
+		{Server: "s5", Reasons: []string{"no info"}},
+	}
+	if !reflect.DeepEqual(mismatches, wantMismatches) {
+		t.Errorf("unexpected mismatches,\ngot =%+v,\nwant=%+v", mismatches, wantMismatches)
+	}
+}